@@ -0,0 +1,223 @@
+// syncthing cli tool - long running agent
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// agentConfig selects the services run by agent, missing ones are not run
+type agentConfig struct {
+	Notify    *notifyConfig  `yaml:"notify"`
+	Scheduler string         `yaml:"scheduler"`
+	Metrics   *metricsConfig `yaml:"metrics"`
+}
+
+// services are the running parts of agent, stopped together on reload
+type services struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	close  []func()
+	failed chan error
+}
+
+func agentCmd(args []string) error {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	file := fs.String("config", "", "agent yaml config file")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("usage: agent --config <agent.yaml>")
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	log.SetFlags(0)
+	// log.Fatal would log the error at INFO level now
+	if err := runAgent(*file); err != nil {
+		slog.Error("agent failed", "err", err)
+		os.Exit(1)
+	}
+	return nil
+}
+
+func runAgent(file string) error {
+	sv, err := startAgent(file)
+	if err != nil {
+		return err
+	}
+	sdNotify("READY=1")
+	slog.Info("agent started", "config", file, "pid", os.Getpid())
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	// the watchdog is only pinged while all services run, a failed one stops
+	// the agent so systemd can restart it
+	wd := time.NewTicker(sdWatchdog())
+	defer wd.Stop()
+	for {
+		select {
+		case <-wd.C:
+			sdNotify("WATCHDOG=1")
+		case err := <-sv.failed:
+			sdNotify("STOPPING=1")
+			sv.stop()
+			return err
+		case s := <-sig:
+			if s != syscall.SIGHUP {
+				slog.Info("agent stopping", "signal", s.String())
+				sdNotify("STOPPING=1")
+				sv.stop()
+				return nil
+			}
+			sdReloading()
+			slog.Info("reloading config", "config", file)
+			// the old services keep running if the new config is broken
+			if _, err := loadAgentConfig(file); err != nil {
+				slog.Error("reload failed", "err", err)
+				sdNotify("READY=1")
+				continue
+			}
+			sv.stop()
+			sv, err = startAgent(file)
+			if err != nil {
+				return err
+			}
+			sdNotify("READY=1")
+		}
+	}
+}
+
+func loadAgentConfig(f string) (agentConfig, error) {
+	b, err := os.ReadFile(f)
+	if err != nil {
+		return agentConfig{}, err
+	}
+	ac := agentConfig{}
+	d := yaml.NewDecoder(strings.NewReader(string(b)))
+	d.KnownFields(true)
+	if err := d.Decode(&ac); err != nil {
+		return agentConfig{}, fmt.Errorf("%v: %w", f, err)
+	}
+	if ac.Notify == nil && ac.Scheduler == "" && ac.Metrics == nil {
+		return agentConfig{}, fmt.Errorf("%v: nothing to run, configure notify, scheduler or metrics", f)
+	}
+	if ac.Notify != nil {
+		if _, err := newNotifier(*ac.Notify); err != nil {
+			return agentConfig{}, fmt.Errorf("%v: notify: %w", f, err)
+		}
+	}
+	if ac.Scheduler != "" {
+		if _, err := loadRules(ac.Scheduler); err != nil {
+			return agentConfig{}, fmt.Errorf("%v: scheduler: %w", f, err)
+		}
+	}
+	return ac, nil
+}
+
+func startAgent(f string) (*services, error) {
+	ac, err := loadAgentConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sv := &services{cancel: cancel, failed: make(chan error, 1)}
+
+	// listeners are bound before anything runs, so a busy port fails the
+	// start instead of a service after READY=1
+	var ln net.Listener
+	if ac.Metrics != nil {
+		ln, err = net.Listen("tcp", ac.Metrics.Listen)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("metrics: %w", err)
+		}
+	}
+
+	if ac.Notify != nil {
+		nt, err := newNotifier(*ac.Notify)
+		if err != nil {
+			if ln != nil {
+				ln.Close()
+			}
+			sv.stop()
+			return nil, err
+		}
+		sv.run(ctx, "notify", nt.run)
+		sv.close = append(sv.close, nt.close)
+	}
+	if ac.Scheduler != "" {
+		sr, err := loadRules(ac.Scheduler)
+		if err != nil {
+			if ln != nil {
+				ln.Close()
+			}
+			sv.stop()
+			return nil, err
+		}
+		sv.run(ctx, "scheduler", func(ctx context.Context) error {
+			return runScheduler(ctx, sr)
+		})
+	}
+	if ln != nil {
+		hs := &http.Server{Handler: metricsHandler()}
+		sv.run(ctx, "metrics", func(ctx context.Context) error {
+			go func() {
+				<-ctx.Done()
+				sc, c := context.WithTimeout(context.Background(), 5*time.Second)
+				defer c()
+				hs.Shutdown(sc)
+			}()
+			err := hs.Serve(ln)
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return err
+		})
+	}
+	return sv, nil
+}
+
+// run starts a service, which returns once ctx is cancelled. Returning
+// earlier is a failure reported on sv.failed.
+func (sv *services) run(ctx context.Context, name string, fn func(context.Context) error) {
+	sv.wg.Add(1)
+	go func() {
+		defer sv.wg.Done()
+		slog.Info("service started", "service", name)
+		err := fn(ctx)
+		if ctx.Err() != nil && err == nil {
+			slog.Info("service stopped", "service", name)
+			return
+		}
+		if err == nil {
+			err = errors.New("stopped unexpectedly")
+		}
+		slog.Error("service failed", "service", name, "err", err)
+		select {
+		case sv.failed <- fmt.Errorf("service %v failed: %w", name, err):
+		default:
+		}
+	}()
+}
+
+func (sv *services) stop() {
+	sv.cancel()
+	sv.wg.Wait()
+	for _, c := range sv.close {
+		c()
+	}
+}
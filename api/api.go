@@ -1,19 +1,21 @@
 package api
 
 import (
-	"crypto/tls"
+	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"net/url"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 )
 
 var (
-	c = resty.New()
+	c    = resty.New()
+	root *url.URL
 )
 
 type StConfig struct {
@@ -25,16 +27,23 @@ type StConfig struct {
 	} `json:"folders"`
 
 	Devices []struct {
-		DeviceID string `json:"deviceID"`
-		Name     string `json:"name"`
-		Paused   bool   `json:"paused"`
+		DeviceID  string   `json:"deviceID"`
+		Name      string   `json:"name"`
+		Paused    bool     `json:"paused"`
+		Addresses []string `json:"addresses"`
 	}
 }
 
 type SysConn map[string]struct {
 	Connected     bool   `json:"connected"`
+	Paused        bool   `json:"paused"`
 	InBytesTotal  uint64 `json:"inBytesTotal"`
 	OutBytesTotal uint64 `json:"outBytesTotal"`
+	Address       string `json:"address"`
+	Type          string `json:"type"`
+	Crypto        string `json:"crypto"`
+	IsLocal       bool   `json:"isLocal"`
+	ClientVersion string `json:"clientVersion"`
 }
 
 type SysConnections struct {
@@ -42,9 +51,21 @@ type SysConnections struct {
 }
 
 type SysStatus struct {
-	MyID   string `json:"myID"`
-	Uptime int64  `json:"uptime"`
-	Ram    uint64 `json:"sys"`
+	MyID      string    `json:"myID"`
+	Uptime    int64     `json:"uptime"`
+	StartTime time.Time `json:"startTime"`
+	Ram       uint64    `json:"sys"`
+
+	// listeners by address, WAN addresses are found by NAT-PMP, UPnP or STUN
+	ConnectionServiceStatus map[string]struct {
+		Error        *string  `json:"error"`
+		LANAddresses []string `json:"lanAddresses"`
+		WANAddresses []string `json:"wanAddresses"`
+	} `json:"connectionServiceStatus"`
+	DiscoveryEnabled bool `json:"discoveryEnabled"`
+	DiscoveryStatus  map[string]struct {
+		Error *string `json:"error"`
+	} `json:"discoveryStatus"`
 }
 
 type SysVersion struct {
@@ -75,6 +96,33 @@ type SysErrors struct {
 	} `json:"errors"`
 }
 
+type Event struct {
+	ID   int             `json:"id"`
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+type SysLog struct {
+	Messages []struct {
+		When    time.Time `json:"when"`
+		Message string    `json:"message"`
+		Level   int       `json:"level"`
+	} `json:"messages"`
+}
+
+type SysUpgrade struct {
+	Running    string `json:"running"`
+	Latest     string `json:"latest"`
+	Newer      bool   `json:"newer"`
+	MajorNewer bool   `json:"majorNewer"`
+}
+
+type SysDebug struct {
+	Enabled    []string          `json:"enabled"`
+	Facilities map[string]string `json:"facilities"`
+}
+
 type FolderErrors struct {
 	Errors []struct {
 		Path  string `json:"path"`
@@ -82,22 +130,99 @@ type FolderErrors struct {
 	} `json:"errors"`
 }
 
+// Discovery is the discovery cache, addresses by device ID
+type Discovery map[string]struct {
+	Addresses []string `json:"addresses"`
+}
+
+type DevStats map[string]struct {
+	LastSeen                time.Time `json:"lastSeen"`
+	LastConnectionDurationS float64   `json:"lastConnectionDurationS"`
+}
+
+type NeedFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// DbNeed is the pull queue of a folder, files being pulled, queued and the
+// rest, not queued yet
+type DbNeed struct {
+	Progress []NeedFile `json:"progress"`
+	Queued   []NeedFile `json:"queued"`
+	Rest     []NeedFile `json:"rest"`
+}
+
 func apiError(e interface{}) error {
 	pc, fi, li, _ := runtime.Caller(1)
 	return fmt.Errorf("%v (%v:%v): %v", runtime.FuncForPC(pc).Name(), filepath.Base(fi), li, e)
 }
 
-func IgnoreCertErrors() {
-	c.SetTransport(&http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}})
-}
-
 func SetApiKeyTarget(a, t string) error {
 	if a == "" || t == "" {
 		return fmt.Errorf("apikey and target must be specified")
 	}
 	c.SetHeader("X-API-Key", a)
-	c.SetBaseURL(t + "/rest/")
-	return nil
+	return setTarget(t)
+}
+
+// reqTimeout limits requests not marked long, so a dead connection fails
+// fast. Long requests are not limited.
+var reqTimeout = time.Minute
+
+type ctxKey int
+
+const (
+	longKey ctxKey = iota
+	timeoutKey
+	loginKey
+)
+
+// reqCtx is the timeout of a single attempt, a retry starts from parent
+type reqCtx struct {
+	parent context.Context
+	cancel context.CancelFunc
+}
+
+func init() {
+	c.OnBeforeRequest(func(_ *resty.Client, r *resty.Request) error {
+		if r.Context().Value(longKey) != nil {
+			return nil
+		}
+		p := r.Context()
+		if rc, ok := p.Value(timeoutKey).(reqCtx); ok {
+			rc.cancel()
+			p = rc.parent
+		}
+		ctx, cancel := context.WithTimeout(p, reqTimeout)
+		r.SetContext(context.WithValue(ctx, timeoutKey, reqCtx{parent: p, cancel: cancel}))
+		return nil
+	})
+	done := func(r *resty.Request) {
+		if rc, ok := r.Context().Value(timeoutKey).(reqCtx); ok {
+			rc.cancel()
+		}
+	}
+	c.OnSuccess(func(_ *resty.Client, r *resty.Response) {
+		done(r.Request)
+	})
+	c.OnError(func(r *resty.Request, _ error) {
+		done(r)
+	})
+}
+
+// long marks requests which may legitimately block, such as event polls,
+// scans or upgrades, to be exempt from the default request timeout
+func long(ctx context.Context) context.Context {
+	return context.WithValue(ctx, longKey, true)
+}
+
+// SetTimeout changes the timeout of requests not marked long
+func SetTimeout(t time.Duration) {
+	if t > 0 {
+		reqTimeout = t
+	}
 }
 
 func GetConfig() (StConfig, error) {
@@ -118,6 +243,155 @@ func GetConfig() (StConfig, error) {
 	return cfg, nil
 }
 
+// GetConfigRaw returns the full config json as served by syncthing
+func GetConfigRaw() ([]byte, error) {
+	r, err := c.R().Get("config")
+	if err != nil {
+		return nil, apiError(err)
+	}
+	if r.IsError() {
+		return nil, apiError(r.Status())
+	}
+	return r.Body(), nil
+}
+
+// PutConfig replaces the whole config with json in b
+func PutConfig(b []byte) error {
+	r, err := c.R().SetHeader("Content-Type", "application/json").SetBody(b).Put("config")
+	if err != nil {
+		return apiError(err)
+	}
+	if r.IsError() {
+		return apiError(r.Status() + ": " + r.String())
+	}
+	return nil
+}
+
+func RestartRequired() (bool, error) {
+	r, err := c.R().Get("config/restart-required")
+	if err != nil {
+		return false, apiError(err)
+	}
+	if r.IsError() {
+		return false, apiError(r.Status())
+	}
+
+	rr := struct {
+		RequiresRestart bool `json:"requiresRestart"`
+	}{}
+	err = json.Unmarshal(r.Body(), &rr)
+	if err != nil {
+		return false, apiError(err)
+	}
+	return rr.RequiresRestart, nil
+}
+
+// GetRaw returns body of any GET endpoint, such as system/connections
+func GetRaw(path string) ([]byte, error) {
+	r, err := c.R().SetContext(long(context.Background())).Get(path)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	if r.IsError() {
+		return nil, apiError(r.Status())
+	}
+	return r.Body(), nil
+}
+
+// GetOptionsRaw returns the daemon wide options json
+func GetOptionsRaw() ([]byte, error) {
+	r, err := c.R().Get("config/options")
+	if err != nil {
+		return nil, apiError(err)
+	}
+	if r.IsError() {
+		return nil, apiError(r.Status())
+	}
+	return r.Body(), nil
+}
+
+// PatchOptions changes only the options present in v
+func PatchOptions(v interface{}) error {
+	r, err := c.R().SetHeader("Content-Type", "application/json").SetBody(v).Patch("config/options")
+	if err != nil {
+		return apiError(err)
+	}
+	if r.IsError() {
+		return apiError(r.Status() + ": " + r.String())
+	}
+	return nil
+}
+
+// PutConfigObject creates or replaces a config object, kind is folders or
+// devices. Fields not present in v are set to defaults.
+func PutConfigObject(kind, id string, v interface{}) error {
+	r, err := c.R().SetHeader("Content-Type", "application/json").SetBody(v).Put("config/" + kind + "/" + url.PathEscape(id))
+	if err != nil {
+		return apiError(err)
+	}
+	if r.IsError() {
+		return apiError(r.Status() + ": " + r.String())
+	}
+	return nil
+}
+
+// PatchConfigObject changes only the fields present in v
+func PatchConfigObject(kind, id string, v interface{}) error {
+	r, err := c.R().SetHeader("Content-Type", "application/json").SetBody(v).Patch("config/" + kind + "/" + url.PathEscape(id))
+	if err != nil {
+		return apiError(err)
+	}
+	if r.IsError() {
+		return apiError(r.Status() + ": " + r.String())
+	}
+	return nil
+}
+
+func DeleteConfigObject(kind, id string) error {
+	r, err := c.R().Delete("config/" + kind + "/" + url.PathEscape(id))
+	if err != nil {
+		return apiError(err)
+	}
+	if r.IsError() {
+		return apiError(r.Status())
+	}
+	return nil
+}
+
+func GetIgnores(folderID string) ([]string, error) {
+	r, err := c.R().SetQueryParam("folder", folderID).Get("db/ignores")
+	if err != nil {
+		return nil, apiError(err)
+	}
+	if r.IsError() {
+		return nil, apiError(r.Status())
+	}
+
+	ig := struct {
+		Ignore []string `json:"ignore"`
+	}{}
+	err = json.Unmarshal(r.Body(), &ig)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	return ig.Ignore, nil
+}
+
+func SetIgnores(folderID string, lines []string) error {
+	r, err := c.R().
+		SetQueryParam("folder", folderID).
+		SetHeader("Content-Type", "application/json").
+		SetBody(map[string][]string{"ignore": lines}).
+		Post("db/ignores")
+	if err != nil {
+		return apiError(err)
+	}
+	if r.IsError() {
+		return apiError(r.Status())
+	}
+	return nil
+}
+
 func GetFolderStatus(f string) (DbStatus, error) {
 	r, err := c.R().SetQueryString("folder=" + f).Get("db/status")
 	if err != nil {
@@ -147,6 +421,17 @@ func PauseFolder(f string, p bool) error {
 	return nil
 }
 
+func PauseDevice(d string, p bool) error {
+	r, err := c.R().SetBody(`{ "paused": ` + strconv.FormatBool(p) + `}`).Patch("config/devices/" + d)
+	if err != nil {
+		return apiError(err)
+	}
+	if r.IsError() {
+		return apiError(r.Status())
+	}
+	return nil
+}
+
 func GetCompletion(qStr string) (DbCompletion, error) {
 	r, err := c.R().SetQueryString(qStr).Get("db/completion")
 	if err != nil {
@@ -186,6 +471,40 @@ func GetConnection() (SysConn, error) {
 	return co.Connections, nil
 }
 
+func GetDiscovery() (Discovery, error) {
+	r, err := c.R().Get("system/discovery")
+	if err != nil {
+		return nil, apiError(err)
+	}
+	if r.IsError() {
+		return nil, apiError(r.Status())
+	}
+
+	d := Discovery{}
+	err = json.Unmarshal(r.Body(), &d)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	return d, nil
+}
+
+func GetDevStats() (DevStats, error) {
+	r, err := c.R().Get("stats/device")
+	if err != nil {
+		return nil, apiError(err)
+	}
+	if r.IsError() {
+		return nil, apiError(r.Status())
+	}
+
+	ds := DevStats{}
+	err = json.Unmarshal(r.Body(), &ds)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	return ds, nil
+}
+
 func GetSysStatus() (SysStatus, error) {
 	r, err := c.R().Get("system/status")
 	if err != nil {
@@ -233,6 +552,99 @@ func GetLogTxt() (string, error) {
 	return r.String(), nil
 }
 
+// GetSysLog returns recent log entries newer than since, all if zero
+func GetSysLog(since time.Time) (SysLog, error) {
+	q := c.R()
+	if !since.IsZero() {
+		q.SetQueryParam("since", since.Format(time.RFC3339Nano))
+	}
+	r, err := q.Get("system/log")
+	if err != nil {
+		return SysLog{}, apiError(err)
+	}
+	if r.IsError() {
+		return SysLog{}, apiError(r.Status())
+	}
+
+	l := SysLog{}
+	err = json.Unmarshal(r.Body(), &l)
+	if err != nil {
+		return SysLog{}, apiError(err)
+	}
+
+	return l, nil
+}
+
+func GetUpgrade() (SysUpgrade, error) {
+	r, err := c.R().Get("system/upgrade")
+	if err != nil {
+		return SysUpgrade{}, apiError(err)
+	}
+	if r.IsError() {
+		return SysUpgrade{}, apiError(r.Status() + ": " + r.String())
+	}
+
+	u := SysUpgrade{}
+	err = json.Unmarshal(r.Body(), &u)
+	if err != nil {
+		return SysUpgrade{}, apiError(err)
+	}
+
+	return u, nil
+}
+
+func Upgrade() error {
+	r, err := c.R().SetContext(long(context.Background())).Post("system/upgrade")
+	if err != nil {
+		return apiError(err)
+	}
+	if r.IsError() {
+		return apiError(r.Status() + ": " + r.String())
+	}
+	return nil
+}
+
+func GetDebug() (SysDebug, error) {
+	r, err := c.R().Get("system/debug")
+	if err != nil {
+		return SysDebug{}, apiError(err)
+	}
+	if r.IsError() {
+		return SysDebug{}, apiError(r.Status())
+	}
+
+	d := SysDebug{}
+	err = json.Unmarshal(r.Body(), &d)
+	if err != nil {
+		return SysDebug{}, apiError(err)
+	}
+
+	return d, nil
+}
+
+// SetDebug enables and disables comma separated debug facilities
+func SetDebug(enable, disable string) error {
+	r, err := c.R().SetQueryParam("enable", enable).SetQueryParam("disable", disable).Post("system/debug")
+	if err != nil {
+		return apiError(err)
+	}
+	if r.IsError() {
+		return apiError(r.Status())
+	}
+	return nil
+}
+
+func Ping() error {
+	r, err := c.R().Get("system/ping")
+	if err != nil {
+		return apiError(err)
+	}
+	if r.IsError() {
+		return apiError(r.Status())
+	}
+	return nil
+}
+
 func Shutdown() error {
 	r, err := c.R().Post("system/shutdown")
 	if err != nil {
@@ -255,8 +667,13 @@ func Restart() error {
 	return nil
 }
 
-func ResetDB() error {
-	r, err := c.R().Post("system/reset")
+// ResetDB resets database of a folder, or of all folders if empty
+func ResetDB(folderID string) error {
+	q := c.R()
+	if folderID != "" {
+		q.SetQueryParam("folder", folderID)
+	}
+	r, err := q.Post("system/reset")
 	if err != nil {
 		return apiError(err)
 	}
@@ -322,8 +739,21 @@ func GetFolderErrors(folderID string) (FolderErrors, error) {
 	return fe, nil
 }
 
-func Rescan(folderID string) error {
-	r, err := c.R().SetQueryString("folder=" + folderID).Post("db/scan")
+// Rescan scans a folder, or all if folderID is empty, and returns when the
+// scan is done. Only subs are scanned if given. A non zero next schedules the
+// next full scan of the folder after that delay.
+func Rescan(folderID string, subs []string, next time.Duration) error {
+	q := url.Values{}
+	if folderID != "" {
+		q.Set("folder", folderID)
+	}
+	for _, s := range subs {
+		q.Add("sub", s)
+	}
+	if next > 0 {
+		q.Set("next", strconv.Itoa(int(next.Seconds())))
+	}
+	r, err := c.R().SetContext(long(context.Background())).SetQueryParamsFromValues(q).Post("db/scan")
 	if err != nil {
 		return apiError(err)
 	}
@@ -333,6 +763,41 @@ func Rescan(folderID string) error {
 	return nil
 }
 
+func GetNeed(folderID string) (DbNeed, error) {
+	r, err := c.R().SetQueryParam("folder", folderID).Get("db/need")
+	if err != nil {
+		return DbNeed{}, apiError(err)
+	}
+	if r.IsError() {
+		return DbNeed{}, apiError(r.Status())
+	}
+
+	dn := DbNeed{}
+	err = json.Unmarshal(r.Body(), &dn)
+	if err != nil {
+		return DbNeed{}, apiError(err)
+	}
+	return dn, nil
+}
+
+// Prio moves file to the front of the pull queue, returning the new queue
+func Prio(folderID, file string) (DbNeed, error) {
+	r, err := c.R().SetQueryParam("folder", folderID).SetQueryParam("file", file).Post("db/prio")
+	if err != nil {
+		return DbNeed{}, apiError(err)
+	}
+	if r.IsError() {
+		return DbNeed{}, apiError(r.Status())
+	}
+
+	dn := DbNeed{}
+	err = json.Unmarshal(r.Body(), &dn)
+	if err != nil {
+		return DbNeed{}, apiError(err)
+	}
+	return dn, nil
+}
+
 func Override(folderID string) error {
 	r, err := c.R().SetQueryString("folder=" + folderID).Post("db/override")
 	if err != nil {
@@ -357,6 +822,7 @@ func Revert(folderID string) error {
 
 func Events(event_types string, limit int, since int) (string, error) {
 	r, err := c.R().
+		SetContext(long(context.Background())).
 		SetQueryString("events=" + event_types).
 		SetQueryString(fmt.Sprintf("since=%d", since)).
 		SetQueryString(fmt.Sprintf("limit=%d", limit)).
@@ -370,3 +836,27 @@ func Events(event_types string, limit int, since int) (string, error) {
 
 	return r.String(), nil
 }
+
+// WaitEvents returns events newer than since, waiting up to timeout for them
+func WaitEvents(ctx context.Context, types string, since int, timeout time.Duration) ([]Event, error) {
+	r, err := c.R().
+		SetContext(long(ctx)).
+		SetQueryParam("events", types).
+		SetQueryParam("since", strconv.Itoa(since)).
+		SetQueryParam("timeout", strconv.Itoa(int(timeout.Seconds()))).
+		Get("events")
+	if err != nil {
+		return nil, apiError(err)
+	}
+	if r.IsError() {
+		return nil, apiError(r.Status())
+	}
+
+	ev := []Event{}
+	err = json.Unmarshal(r.Body(), &ev)
+	if err != nil {
+		return nil, apiError(err)
+	}
+
+	return ev, nil
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/go-resty/resty/v2"
+)
+
+var (
+	jar = &sessionJar{}
+
+	// credentials of the session, to log in again once it expires
+	session struct {
+		sync.Mutex
+		user, pass, cache string
+	}
+
+	// X-CSRF-Token-* headers added to each request
+	csrf struct {
+		sync.RWMutex
+		h map[string]string
+	}
+)
+
+func init() {
+	jar.reset()
+	c.SetCookieJar(jar)
+	c.SetRetryCount(1)
+	// errors are returned, resty would log them too once retries are on
+	c.SetLogger(quiet{})
+	c.AddRetryCondition(relogin)
+	c.OnBeforeRequest(func(_ *resty.Client, r *resty.Request) error {
+		csrf.RLock()
+		defer csrf.RUnlock()
+		for k, v := range csrf.h {
+			r.Header.Set(k, v)
+		}
+		return nil
+	})
+}
+
+type quiet struct{}
+
+func (quiet) Errorf(string, ...interface{}) {}
+func (quiet) Warnf(string, ...interface{})  {}
+func (quiet) Debugf(string, ...interface{}) {}
+
+// sessionJar is the cookie jar of the client, it can be emptied while
+// requests are made
+type sessionJar struct {
+	sync.RWMutex
+	j *cookiejar.Jar
+}
+
+func (s *sessionJar) SetCookies(u *url.URL, ck []*http.Cookie) {
+	s.RLock()
+	defer s.RUnlock()
+	s.j.SetCookies(u, ck)
+}
+
+func (s *sessionJar) Cookies(u *url.URL) []*http.Cookie {
+	s.RLock()
+	defer s.RUnlock()
+	return s.j.Cookies(u)
+}
+
+func (s *sessionJar) reset() {
+	s.Lock()
+	defer s.Unlock()
+	s.j, _ = cookiejar.New(nil)
+}
+
+// relogin logs in again when a request is refused, as the session expired
+// or syncthing restarted, and has the request retried once. The retry picks
+// up the new CSRF token in the OnBeforeRequest hook.
+func relogin(r *resty.Response, err error) bool {
+	if err != nil || r == nil || r.StatusCode() != http.StatusUnauthorized && r.StatusCode() != http.StatusForbidden {
+		return false
+	}
+	if r.Request.Context().Value(loginKey) != nil {
+		return false
+	}
+	session.Lock()
+	defer session.Unlock()
+	if session.user == "" {
+		return false
+	}
+	// another request may have logged in already
+	if sessionValid() {
+		return true
+	}
+	return login(session.user, session.pass, session.cache) == nil
+}
+
+// loginReq is a request made while logging in, never retried by relogin
+func loginReq() *resty.Request {
+	return c.R().SetContext(context.WithValue(context.Background(), loginKey, true))
+}
+
+// SetUserTarget configures GUI user/password authentication. A session is
+// established with Login before any other call is made.
+func SetUserTarget(u, t string) error {
+	if u == "" || t == "" {
+		return fmt.Errorf("user and target must be specified")
+	}
+	return setTarget(t)
+}
+
+// Login establishes a GUI session using a cached session cookie from the
+// cache file if it is still valid, or by logging in with user and password.
+// The new session is written back to the cache file. An empty cache file
+// name disables caching. Once the session expires, it logs in again.
+func Login(user, pass, cache string) error {
+	session.Lock()
+	defer session.Unlock()
+	session.user, session.pass, session.cache = user, pass, cache
+	return login(user, pass, cache)
+}
+
+func login(user, pass, cache string) error {
+	if cache != "" {
+		loadSession(cache)
+		if sessionValid() {
+			return nil
+		}
+		jar.reset()
+	}
+
+	// fetch the GUI root page to obtain a CSRF cookie
+	_, err := loginReq().Get(root.String())
+	if err != nil {
+		return apiError(err)
+	}
+	setCsrf()
+
+	r, err := loginReq().
+		SetHeader("Content-Type", "application/json").
+		SetBody(map[string]interface{}{"username": user, "password": pass, "stayLoggedIn": true}).
+		Post("noauth/auth/password")
+	if err != nil {
+		return apiError(err)
+	}
+	switch {
+	case r.StatusCode() == http.StatusNotFound:
+		// syncthing older than v1.27 has no login endpoint, basic auth
+		// on the GUI root sets the session cookie instead
+		r, err = loginReq().SetBasicAuth(user, pass).Get(root.String())
+		if err != nil {
+			return apiError(err)
+		}
+		if r.IsError() {
+			return apiError(r.Status())
+		}
+	case r.IsError():
+		return apiError(r.Status())
+	}
+	setCsrf()
+
+	if !sessionValid() {
+		return fmt.Errorf("login as %q failed", user)
+	}
+	if cache != "" {
+		return saveSession(cache)
+	}
+	return nil
+}
+
+func sessionValid() bool {
+	r, err := loginReq().Get("system/ping")
+	return err == nil && r.IsSuccess()
+}
+
+// setCsrf copies CSRF-Token-* cookies to the matching X-CSRF-Token-* headers
+func setCsrf() {
+	h := map[string]string{}
+	for _, k := range jar.Cookies(root) {
+		if !strings.HasPrefix(k.Name, "CSRF-Token") {
+			continue
+		}
+		h["X-"+k.Name] = k.Value
+	}
+	csrf.Lock()
+	defer csrf.Unlock()
+	csrf.h = h
+}
+
+func loadSession(cache string) {
+	fi, err := os.Stat(cache)
+	if err != nil {
+		return
+	}
+	// do not trust a session file that others could have written
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		return
+	}
+	b, err := os.ReadFile(cache)
+	if err != nil {
+		return
+	}
+	m := map[string]string{}
+	if json.Unmarshal(b, &m) != nil {
+		return
+	}
+	ck := []*http.Cookie{}
+	for n, v := range m {
+		ck = append(ck, &http.Cookie{Name: n, Value: v})
+	}
+	jar.SetCookies(root, ck)
+	setCsrf()
+}
+
+func saveSession(cache string) error {
+	m := map[string]string{}
+	for _, k := range jar.Cookies(root) {
+		m[k.Name] = k.Value
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cache), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(cache, b, 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an already existing file
+	return os.Chmod(cache, 0600)
+}
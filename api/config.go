@@ -0,0 +1,305 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// Config is the full syncthing configuration as stored in config.xml, with
+// json tags matching /rest/config
+type Config struct {
+	XMLName        xml.Name         `xml:"configuration" json:"-"`
+	Version        int              `xml:"version,attr" json:"version"`
+	Folders        []FolderConfig   `xml:"folder" json:"folders"`
+	Devices        []DeviceConfig   `xml:"device" json:"devices"`
+	GUI            GUIConfig        `xml:"gui" json:"gui"`
+	LDAP           LDAPConfig       `xml:"ldap" json:"ldap"`
+	Options        OptionsConfig    `xml:"options" json:"options"`
+	IgnoredDevices []ObservedDevice `xml:"remoteIgnoredDevice" json:"remoteIgnoredDevices"`
+	Defaults       DefaultsConfig   `xml:"defaults" json:"defaults"`
+}
+
+type FolderConfig struct {
+	ID                      string               `xml:"id,attr" json:"id"`
+	Label                   string               `xml:"label,attr" json:"label"`
+	FilesystemType          string               `xml:"filesystemType" json:"filesystemType"`
+	Path                    string               `xml:"path,attr" json:"path"`
+	Type                    string               `xml:"type,attr" json:"type"`
+	Devices                 []FolderDeviceConfig `xml:"device" json:"devices"`
+	RescanIntervalS         int                  `xml:"rescanIntervalS,attr" json:"rescanIntervalS"`
+	FSWatcherEnabled        bool                 `xml:"fsWatcherEnabled,attr" json:"fsWatcherEnabled"`
+	FSWatcherDelayS         float64              `xml:"fsWatcherDelayS,attr" json:"fsWatcherDelayS"`
+	FSWatcherTimeoutS       float64              `xml:"fsWatcherTimeoutS,attr" json:"fsWatcherTimeoutS"`
+	IgnorePerms             bool                 `xml:"ignorePerms,attr" json:"ignorePerms"`
+	AutoNormalize           bool                 `xml:"autoNormalize,attr" json:"autoNormalize"`
+	MinDiskFree             Size                 `xml:"minDiskFree" json:"minDiskFree"`
+	Versioning              VersioningConfig     `xml:"versioning" json:"versioning"`
+	Copiers                 int                  `xml:"copiers" json:"copiers"`
+	PullerMaxPendingKiB     int                  `xml:"pullerMaxPendingKiB" json:"pullerMaxPendingKiB"`
+	Hashers                 int                  `xml:"hashers" json:"hashers"`
+	Order                   string               `xml:"order" json:"order"`
+	IgnoreDelete            bool                 `xml:"ignoreDelete" json:"ignoreDelete"`
+	ScanProgressIntervalS   int                  `xml:"scanProgressIntervalS" json:"scanProgressIntervalS"`
+	PullerPauseS            int                  `xml:"pullerPauseS" json:"pullerPauseS"`
+	MaxConflicts            int                  `xml:"maxConflicts" json:"maxConflicts"`
+	DisableSparseFiles      bool                 `xml:"disableSparseFiles" json:"disableSparseFiles"`
+	DisableTempIndexes      bool                 `xml:"disableTempIndexes" json:"disableTempIndexes"`
+	Paused                  bool                 `xml:"paused" json:"paused"`
+	WeakHashThresholdPct    int                  `xml:"weakHashThresholdPct" json:"weakHashThresholdPct"`
+	MarkerName              string               `xml:"markerName" json:"markerName"`
+	CopyOwnershipFromParent bool                 `xml:"copyOwnershipFromParent" json:"copyOwnershipFromParent"`
+	ModTimeWindowS          int                  `xml:"modTimeWindowS" json:"modTimeWindowS"`
+	MaxConcurrentWrites     int                  `xml:"maxConcurrentWrites" json:"maxConcurrentWrites"`
+	DisableFsync            bool                 `xml:"disableFsync" json:"disableFsync"`
+	BlockPullOrder          string               `xml:"blockPullOrder" json:"blockPullOrder"`
+	CopyRangeMethod         string               `xml:"copyRangeMethod" json:"copyRangeMethod"`
+	CaseSensitiveFS         bool                 `xml:"caseSensitiveFS" json:"caseSensitiveFS"`
+	JunctionsAsDirs         bool                 `xml:"junctionsAsDirs" json:"junctionsAsDirs"`
+	SyncOwnership           bool                 `xml:"syncOwnership" json:"syncOwnership"`
+	SendOwnership           bool                 `xml:"sendOwnership" json:"sendOwnership"`
+	SyncXattrs              bool                 `xml:"syncXattrs" json:"syncXattrs"`
+	SendXattrs              bool                 `xml:"sendXattrs" json:"sendXattrs"`
+	XattrFilter             XattrFilter          `xml:"xattrFilter" json:"xattrFilter"`
+}
+
+type FolderDeviceConfig struct {
+	DeviceID           string `xml:"id,attr" json:"deviceID"`
+	IntroducedBy       string `xml:"introducedBy,attr" json:"introducedBy"`
+	EncryptionPassword string `xml:"encryptionPassword" json:"encryptionPassword"`
+}
+
+type Size struct {
+	Value float64 `xml:",chardata" json:"value"`
+	Unit  string  `xml:"unit,attr" json:"unit"`
+}
+
+func (s Size) String() string {
+	return fmt.Sprintf("%v %v", s.Value, s.Unit)
+}
+
+// VersioningConfig params are a key/val element list in xml and an object in
+// json, XMLParams is copied to Params by ParseConfigXML
+type VersioningConfig struct {
+	Type             string            `xml:"type,attr" json:"type"`
+	XMLParams        []VersioningParam `xml:"param" json:"-"`
+	Params           map[string]string `xml:"-" json:"params"`
+	CleanupIntervalS int               `xml:"cleanupIntervalS" json:"cleanupIntervalS"`
+	FSPath           string            `xml:"fsPath" json:"fsPath"`
+	FSType           string            `xml:"fsType" json:"fsType"`
+}
+
+type VersioningParam struct {
+	Key string `xml:"key,attr"`
+	Val string `xml:"val,attr"`
+}
+
+type XattrFilter struct {
+	Entries []struct {
+		Match  string `xml:"match,attr" json:"match"`
+		Permit bool   `xml:"permit,attr" json:"permit"`
+	} `xml:"entry" json:"entries"`
+	MaxSingleEntrySize int `xml:"maxSingleEntrySize" json:"maxSingleEntrySize"`
+	MaxTotalSize       int `xml:"maxTotalSize" json:"maxTotalSize"`
+}
+
+type DeviceConfig struct {
+	DeviceID                 string           `xml:"id,attr" json:"deviceID"`
+	Name                     string           `xml:"name,attr" json:"name"`
+	Addresses                []string         `xml:"address" json:"addresses"`
+	Compression              string           `xml:"compression,attr" json:"compression"`
+	CertName                 string           `xml:"certName" json:"certName"`
+	Introducer               bool             `xml:"introducer,attr" json:"introducer"`
+	SkipIntroductionRemovals bool             `xml:"skipIntroductionRemovals,attr" json:"skipIntroductionRemovals"`
+	IntroducedBy             string           `xml:"introducedBy,attr" json:"introducedBy"`
+	Paused                   bool             `xml:"paused" json:"paused"`
+	AllowedNetworks          []string         `xml:"allowedNetwork" json:"allowedNetworks"`
+	AutoAcceptFolders        bool             `xml:"autoAcceptFolders" json:"autoAcceptFolders"`
+	MaxSendKbps              int              `xml:"maxSendKbps" json:"maxSendKbps"`
+	MaxRecvKbps              int              `xml:"maxRecvKbps" json:"maxRecvKbps"`
+	IgnoredFolders           []ObservedFolder `xml:"ignoredFolder" json:"ignoredFolders"`
+	MaxRequestKiB            int              `xml:"maxRequestKiB" json:"maxRequestKiB"`
+	Untrusted                bool             `xml:"untrusted" json:"untrusted"`
+	RemoteGUIPort            int              `xml:"remoteGUIPort" json:"remoteGUIPort"`
+	NumConnections           int              `xml:"numConnections" json:"numConnections"`
+}
+
+type ObservedFolder struct {
+	Time  string `xml:"time,attr" json:"time"`
+	ID    string `xml:"id,attr" json:"id"`
+	Label string `xml:"label,attr" json:"label"`
+}
+
+type ObservedDevice struct {
+	Time     string `xml:"time,attr" json:"time"`
+	DeviceID string `xml:"id,attr" json:"deviceID"`
+	Name     string `xml:"name,attr" json:"name"`
+	Address  string `xml:"address,attr" json:"address"`
+}
+
+type GUIConfig struct {
+	Enabled                   bool   `xml:"enabled,attr" json:"enabled"`
+	Address                   string `xml:"address" json:"address"`
+	UnixSocketPermissions     string `xml:"unixSocketPermissions" json:"unixSocketPermissions"`
+	User                      string `xml:"user" json:"user"`
+	Password                  string `xml:"password" json:"password"`
+	AuthMode                  string `xml:"authMode" json:"authMode"`
+	UseTLS                    bool   `xml:"tls,attr" json:"useTLS"`
+	APIKey                    string `xml:"apikey" json:"apiKey"`
+	InsecureAdminAccess       bool   `xml:"insecureAdminAccess" json:"insecureAdminAccess"`
+	Theme                     string `xml:"theme" json:"theme"`
+	Debugging                 bool   `xml:"debugging,attr" json:"debugging"`
+	InsecureSkipHostCheck     bool   `xml:"insecureSkipHostcheck" json:"insecureSkipHostcheck"`
+	InsecureAllowFrameLoading bool   `xml:"insecureAllowFrameLoading" json:"insecureAllowFrameLoading"`
+	SendBasicAuthPrompt       bool   `xml:"sendBasicAuthPrompt,attr" json:"sendBasicAuthPrompt"`
+}
+
+type LDAPConfig struct {
+	Address            string `xml:"address" json:"address"`
+	BindDN             string `xml:"bindDN" json:"bindDN"`
+	Transport          string `xml:"transport" json:"transport"`
+	InsecureSkipVerify bool   `xml:"insecureSkipVerify" json:"insecureSkipVerify"`
+	SearchBaseDN       string `xml:"searchBaseDN" json:"searchBaseDN"`
+	SearchFilter       string `xml:"searchFilter" json:"searchFilter"`
+}
+
+type OptionsConfig struct {
+	ListenAddresses             []string `xml:"listenAddress" json:"listenAddresses"`
+	GlobalAnnounceServers       []string `xml:"globalAnnounceServer" json:"globalAnnounceServers"`
+	GlobalAnnounceEnabled       bool     `xml:"globalAnnounceEnabled" json:"globalAnnounceEnabled"`
+	LocalAnnounceEnabled        bool     `xml:"localAnnounceEnabled" json:"localAnnounceEnabled"`
+	LocalAnnouncePort           int      `xml:"localAnnouncePort" json:"localAnnouncePort"`
+	LocalAnnounceMCAddr         string   `xml:"localAnnounceMCAddr" json:"localAnnounceMCAddr"`
+	MaxSendKbps                 int      `xml:"maxSendKbps" json:"maxSendKbps"`
+	MaxRecvKbps                 int      `xml:"maxRecvKbps" json:"maxRecvKbps"`
+	ReconnectIntervalS          int      `xml:"reconnectionIntervalS" json:"reconnectionIntervalS"`
+	RelaysEnabled               bool     `xml:"relaysEnabled" json:"relaysEnabled"`
+	RelayReconnectIntervalM     int      `xml:"relayReconnectIntervalM" json:"relayReconnectIntervalM"`
+	StartBrowser                bool     `xml:"startBrowser" json:"startBrowser"`
+	NATEnabled                  bool     `xml:"natEnabled" json:"natEnabled"`
+	NATLeaseM                   int      `xml:"natLeaseMinutes" json:"natLeaseMinutes"`
+	NATRenewalM                 int      `xml:"natRenewalMinutes" json:"natRenewalMinutes"`
+	NATTimeoutS                 int      `xml:"natTimeoutSeconds" json:"natTimeoutSeconds"`
+	URAccepted                  int      `xml:"urAccepted" json:"urAccepted"`
+	URSeen                      int      `xml:"urSeen" json:"urSeen"`
+	URUniqueID                  string   `xml:"urUniqueID" json:"urUniqueId"`
+	URURL                       string   `xml:"urURL" json:"urURL"`
+	URPostInsecurely            bool     `xml:"urPostInsecurely" json:"urPostInsecurely"`
+	URInitialDelayS             int      `xml:"urInitialDelayS" json:"urInitialDelayS"`
+	AutoUpgradeIntervalH        int      `xml:"autoUpgradeIntervalH" json:"autoUpgradeIntervalH"`
+	UpgradeToPreReleases        bool     `xml:"upgradeToPreReleases" json:"upgradeToPreReleases"`
+	KeepTemporariesH            int      `xml:"keepTemporariesH" json:"keepTemporariesH"`
+	CacheIgnoredFiles           bool     `xml:"cacheIgnoredFiles" json:"cacheIgnoredFiles"`
+	ProgressUpdateIntervalS     int      `xml:"progressUpdateIntervalS" json:"progressUpdateIntervalS"`
+	LimitBandwidthInLan         bool     `xml:"limitBandwidthInLan" json:"limitBandwidthInLan"`
+	MinHomeDiskFree             Size     `xml:"minHomeDiskFree" json:"minHomeDiskFree"`
+	ReleasesURL                 string   `xml:"releasesURL" json:"releasesURL"`
+	AlwaysLocalNets             []string `xml:"alwaysLocalNet" json:"alwaysLocalNets"`
+	OverwriteRemoteDevNames     bool     `xml:"overwriteRemoteDeviceNamesOnConnect" json:"overwriteRemoteDeviceNamesOnConnect"`
+	TempIndexMinBlocks          int      `xml:"tempIndexMinBlocks" json:"tempIndexMinBlocks"`
+	UnackedNotificationIDs      []string `xml:"unackedNotificationID" json:"unackedNotificationIDs"`
+	TrafficClass                int      `xml:"trafficClass" json:"trafficClass"`
+	SetLowPriority              bool     `xml:"setLowPriority" json:"setLowPriority"`
+	MaxFolderConcurrency        int      `xml:"maxFolderConcurrency" json:"maxFolderConcurrency"`
+	CRURL                       string   `xml:"crashReportingURL" json:"crURL"`
+	CREnabled                   bool     `xml:"crashReportingEnabled" json:"crashReportingEnabled"`
+	StunKeepaliveStartS         int      `xml:"stunKeepaliveStartS" json:"stunKeepaliveStartS"`
+	StunKeepaliveMinS           int      `xml:"stunKeepaliveMinS" json:"stunKeepaliveMinS"`
+	StunServers                 []string `xml:"stunServer" json:"stunServers"`
+	DatabaseTuning              string   `xml:"databaseTuning" json:"databaseTuning"`
+	MaxConcurrentIncomingReqKiB int      `xml:"maxConcurrentIncomingRequestKiB" json:"maxConcurrentIncomingRequestKiB"`
+	AnnounceLANAddresses        bool     `xml:"announceLANAddresses" json:"announceLANAddresses"`
+	SendFullIndexOnUpgrade      bool     `xml:"sendFullIndexOnUpgrade" json:"sendFullIndexOnUpgrade"`
+	FeatureFlags                []string `xml:"featureFlag" json:"featureFlags"`
+	ConnectionLimitEnough       int      `xml:"connectionLimitEnough" json:"connectionLimitEnough"`
+	ConnectionLimitMax          int      `xml:"connectionLimitMax" json:"connectionLimitMax"`
+	InsecureAllowOldTLSVersions bool     `xml:"insecureAllowOldTLSVersions" json:"insecureAllowOldTLSVersions"`
+}
+
+type DefaultsConfig struct {
+	Folder  FolderConfig `xml:"folder" json:"folder"`
+	Device  DeviceConfig `xml:"device" json:"device"`
+	Ignores struct {
+		Lines []string `xml:"line" json:"lines"`
+	} `xml:"ignores" json:"ignores"`
+}
+
+// ParseConfigXML decodes contents of syncthing config.xml
+func ParseConfigXML(b []byte) (Config, error) {
+	cfg := Config{}
+	if err := xml.Unmarshal(b, &cfg); err != nil {
+		return Config{}, err
+	}
+	for i := range cfg.Folders {
+		cfg.Folders[i].Versioning.copyParams()
+	}
+	cfg.Defaults.Folder.Versioning.copyParams()
+	return cfg, nil
+}
+
+func (v *VersioningConfig) copyParams() {
+	v.Params = map[string]string{}
+	for _, p := range v.XMLParams {
+		v.Params[p.Key] = p.Val
+	}
+}
+
+const luhnAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+
+// CheckDeviceID verifies format and check characters of a device ID
+func CheckDeviceID(id string) error {
+	s := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(id))
+	if len(s) != 56 {
+		return fmt.Errorf("device ID %q has wrong length", id)
+	}
+	for i := 0; i < 4; i++ {
+		g := s[i*14 : i*14+14]
+		c, err := luhnBase32(g[:13])
+		if err != nil {
+			return fmt.Errorf("device ID %q: %w", id, err)
+		}
+		if c != g[13] {
+			return fmt.Errorf("device ID %q has invalid check character", id)
+		}
+	}
+	return nil
+}
+
+// DeviceIDFromCert computes device ID from DER encoded certificate
+func DeviceIDFromCert(der []byte) string {
+	h := sha256.Sum256(der)
+	s := strings.TrimRight(base32.StdEncoding.EncodeToString(h[:]), "=")
+	id := ""
+	for i := 0; i < 4; i++ {
+		g := s[i*13 : i*13+13]
+		c, _ := luhnBase32(g)
+		id += g + string(c)
+	}
+	ch := []string{}
+	for i := 0; i < len(id); i += 7 {
+		ch = append(ch, id[i:i+7])
+	}
+	return strings.Join(ch, "-")
+}
+
+// luhnBase32 computes the check character the way syncthing does, which
+// differs from the standard Luhn mod N algorithm
+func luhnBase32(s string) (byte, error) {
+	factor, sum := 1, 0
+	for i := range s {
+		cp := strings.IndexByte(luhnAlphabet, s[i])
+		if cp < 0 {
+			return 0, fmt.Errorf("invalid character %q", s[i])
+		}
+		a := factor * cp
+		if factor == 2 {
+			factor = 1
+		} else {
+			factor = 2
+		}
+		sum += a/32 + a%32
+	}
+	return luhnAlphabet[(32-sum%32)%32], nil
+}
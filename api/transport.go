@@ -0,0 +1,200 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	tr       = http.DefaultTransport.(*http.Transport).Clone()
+	insecure bool
+	roots    *x509.CertPool
+	pins     [][]byte // user pinned fingerprints, the only ones accepted if set
+	trusted  [][]byte // fingerprints accepted in addition to the CA roots
+	dialer   = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+)
+
+func init() {
+	// certificates are verified in verifyConn rather than by crypto/tls so
+	// that pinned and home directory certificates don't need a matching name
+	tr.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyConnection:   verifyConn,
+	}
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialer(ctx, network, addr)
+	}
+	c.SetTransport(tr)
+}
+
+// SetDialer makes all connections to the target using d, for example
+// through an ssh tunnel
+func SetDialer(d func(ctx context.Context, network, addr string) (net.Conn, error)) {
+	tr.Proxy = nil
+	dialer = d
+}
+
+func IgnoreCertErrors() {
+	insecure = true
+}
+
+// AddRootCA adds PEM encoded CA certificates to the trusted roots
+func AddRootCA(p []byte) error {
+	if roots == nil {
+		r, err := x509.SystemCertPool()
+		if err != nil {
+			r = x509.NewCertPool()
+		}
+		roots = r
+	}
+	if !roots.AppendCertsFromPEM(p) {
+		return fmt.Errorf("no certificates found in CA file")
+	}
+	return nil
+}
+
+// TrustCert accepts the PEM encoded certificate when presented by the
+// server, regardless of its issuer or names. Used for the self-signed
+// https-cert.pem from syncthing home directory.
+func TrustCert(p []byte) error {
+	b, _ := pem.Decode(p)
+	if b == nil || b.Type != "CERTIFICATE" {
+		return fmt.Errorf("no certificate found")
+	}
+	fp := sha256.Sum256(b.Bytes)
+	trusted = append(trusted, fp[:])
+	return nil
+}
+
+// SetPins restricts accepted server certificates to those with the given
+// SHA-256 fingerprints, in form of comma separated sha256:hex values.
+func SetPins(p string) error {
+	for _, s := range strings.Split(p, ",") {
+		s = strings.TrimSpace(s)
+		h, ok := strings.CutPrefix(strings.ToLower(s), "sha256:")
+		if !ok {
+			return fmt.Errorf("pin %q must start with sha256:", s)
+		}
+		fp, err := hex.DecodeString(strings.ReplaceAll(h, ":", ""))
+		if err != nil || len(fp) != sha256.Size {
+			return fmt.Errorf("pin %q is not a valid sha256 fingerprint", s)
+		}
+		pins = append(pins, fp)
+	}
+	return nil
+}
+
+// SetClientCert presents a client certificate, for reverse proxies
+// requiring mutual TLS
+func SetClientCert(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	tr.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	return nil
+}
+
+// verifyConn checks pins even when insecure, which only skips verification
+// of the chain and host name
+func verifyConn(cs tls.ConnectionState) error {
+	if len(pins) > 0 {
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("server presented no certificate")
+		}
+		fp := sha256.Sum256(cs.PeerCertificates[0].Raw)
+		if fpMatch(pins, fp[:]) {
+			return nil
+		}
+		return fmt.Errorf("server certificate sha256:%x does not match pinned fingerprint", fp)
+	}
+	if insecure {
+		return nil
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("server presented no certificate")
+	}
+	leaf := cs.PeerCertificates[0]
+	fp := sha256.Sum256(leaf.Raw)
+	if fpMatch(trusted, fp[:]) {
+		return nil
+	}
+	// no SNI is sent for IP address targets
+	name := cs.ServerName
+	if name == "" && root != nil {
+		name = root.Hostname()
+	}
+	if name == "" {
+		return fmt.Errorf("unable to verify server certificate without a host name")
+	}
+	im := x509.NewCertPool()
+	for _, ic := range cs.PeerCertificates[1:] {
+		im.AddCert(ic)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       name,
+		Intermediates: im,
+	})
+	return err
+}
+
+func fpMatch(l [][]byte, fp []byte) bool {
+	for _, p := range l {
+		if bytes.Equal(p, fp) {
+			return true
+		}
+	}
+	return false
+}
+
+// setTarget sets the base URL for all calls. Targets in form of
+// unix:///path/to/gui.sock are dialed over a unix domain socket.
+func setTarget(t string) error {
+	u, err := rootURL(t)
+	if err != nil {
+		return err
+	}
+	root = u
+	c.SetBaseURL(u.String() + "rest/")
+	return nil
+}
+
+// rootURL returns the GUI root URL for a target
+func rootURL(t string) (*url.URL, error) {
+	if strings.HasPrefix(t, "unix://") {
+		return unixURL(strings.TrimPrefix(t, "unix://"))
+	}
+	u, err := url.Parse(strings.TrimSuffix(t, "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid target url %q", t)
+	}
+	return u, nil
+}
+
+// unixURL points the transport at a unix socket and returns a placeholder
+// http URL used for requests sent over it
+func unixURL(sock string) (*url.URL, error) {
+	if sock == "" {
+		return nil, fmt.Errorf("unix socket path must be specified")
+	}
+	tr.Proxy = nil
+	tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer(ctx, "unix", sock)
+	}
+	return &url.URL{Scheme: "http", Host: "localhost", Path: "/"}, nil
+}
@@ -0,0 +1,419 @@
+// syncthing cli tool - declarative desired state
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/tenox7/stc/api"
+	"gopkg.in/yaml.v3"
+)
+
+// desiredState is the yaml file given to apply. Folder and device entries
+// use the same field names as /rest/config, except devices of a folder are
+// a list of device names or IDs and ignores is a list of ignore patterns.
+type desiredState struct {
+	Prune   bool                     `yaml:"prune"`
+	Devices []map[string]interface{} `yaml:"devices"`
+	Folders []map[string]interface{} `yaml:"folders"`
+}
+
+// planItem is a single object to add (+), change (~) or remove (-)
+type planItem struct {
+	Op      string
+	Kind    string
+	ID      string
+	Name    string
+	Obj     map[string]interface{}
+	Ignores []string
+	Changes []change
+}
+
+type plan []planItem
+
+func apply(args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	file := fs.String("f", "", "desired state yaml file")
+	auto := fs.Bool("auto_approve", false, "apply changes without asking")
+	pos, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *file == "" && len(pos) == 1 {
+		*file = pos[0]
+	}
+	if *file == "" {
+		return fmt.Errorf("usage: apply -f <desired.yaml> [--auto_approve]")
+	}
+
+	ds, err := loadDesired(*file)
+	if err != nil {
+		return err
+	}
+	p, err := makePlan(ds)
+	if err != nil {
+		return err
+	}
+	p.print()
+	if len(p) == 0 {
+		return nil
+	}
+	if !*auto && !confirm("Apply these changes?") {
+		return fmt.Errorf("apply cancelled, use --auto_approve to apply non-interactively")
+	}
+	return p.apply()
+}
+
+func loadDesired(f string) (desiredState, error) {
+	b, err := os.ReadFile(f)
+	if err != nil {
+		return desiredState{}, err
+	}
+	ds := desiredState{}
+	d := yaml.NewDecoder(strings.NewReader(string(b)))
+	d.KnownFields(true)
+	if err := d.Decode(&ds); err != nil {
+		return desiredState{}, fmt.Errorf("%v: %w", f, err)
+	}
+	for i, dv := range ds.Devices {
+		if id, ok := dv["id"]; ok {
+			dv["deviceID"] = id
+			delete(dv, "id")
+		}
+		if err := checkFields(dv, api.DeviceConfig{}); err != nil {
+			return desiredState{}, fmt.Errorf("%v: device %v: %w", f, i+1, err)
+		}
+		if s, _ := dv["deviceID"].(string); s == "" {
+			return desiredState{}, fmt.Errorf("%v: device %v has no id", f, i+1)
+		}
+	}
+	for i, fo := range ds.Folders {
+		if err := checkFields(fo, api.FolderConfig{}, "ignores", "devices"); err != nil {
+			return desiredState{}, fmt.Errorf("%v: folder %v: %w", f, i+1, err)
+		}
+		if s, _ := fo["id"].(string); s == "" {
+			return desiredState{}, fmt.Errorf("%v: folder %v has no id", f, i+1)
+		}
+	}
+	return ds, nil
+}
+
+// checkFields verifies that keys of o are json fields of the typed model
+// and values have the right types, keys in skip are not checked
+func checkFields(o map[string]interface{}, model interface{}, skip ...string) error {
+	t := reflect.TypeOf(model)
+	known := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		known[strings.Split(t.Field(i).Tag.Get("json"), ",")[0]] = true
+	}
+	c := map[string]interface{}{}
+	for k, v := range o {
+		if k == "versioning" {
+			stringParams(v)
+		}
+		if slices.Contains(skip, k) {
+			continue
+		}
+		if !known[k] || k == "-" {
+			return fmt.Errorf("unknown field %q", k)
+		}
+		c[k] = v
+	}
+	j, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	m := reflect.New(t).Interface()
+	return json.Unmarshal(j, m)
+}
+
+// stringParams converts versioning params to strings, as syncthing expects
+func stringParams(v interface{}) {
+	vc, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	pr, ok := vc["params"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for k, p := range pr {
+		pr[k] = fmt.Sprint(p)
+	}
+}
+
+func makePlan(ds desiredState) (plan, error) {
+	st, err := api.GetSysStatus()
+	if err != nil {
+		return nil, err
+	}
+	b, err := api.GetConfigRaw()
+	if err != nil {
+		return nil, err
+	}
+	live := struct {
+		Folders []map[string]interface{} `json:"folders"`
+		Devices []map[string]interface{} `json:"devices"`
+	}{}
+	if err := json.Unmarshal(b, &live); err != nil {
+		return nil, err
+	}
+	lf := indexObjs(live.Folders, "id")
+	ld := indexObjs(live.Devices, "deviceID")
+
+	// device names resolve to IDs for folder sharing
+	names := map[string]string{}
+	for _, d := range append(live.Devices, ds.Devices...) {
+		id, _ := d["deviceID"].(string)
+		if n, ok := d["name"].(string); ok && n != "" {
+			names[n] = id
+		}
+	}
+
+	p := plan{}
+	want := map[string]bool{st.MyID: true}
+	for _, d := range ds.Devices {
+		d = jsonCopy(d)
+		id := d["deviceID"].(string)
+		want[id] = true
+		p.addObj("devices", id, d, ld[id])
+	}
+
+	wantF := map[string]bool{}
+	for _, f := range ds.Folders {
+		f = jsonCopy(f)
+		id := f["id"].(string)
+		wantF[id] = true
+
+		if dl, ok := f["devices"]; ok {
+			l, ok := dl.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("folder %v: devices must be a list", id)
+			}
+			fd, err := folderDevices(l, names, st.MyID, ld, lf[id])
+			if err != nil {
+				return nil, fmt.Errorf("folder %v: %w", id, err)
+			}
+			f["devices"] = fd
+		}
+
+		var ig []string
+		igSet := false
+		if il, ok := f["ignores"]; ok {
+			igSet = true
+			ll, ok := il.([]interface{})
+			if !ok && il != nil {
+				return nil, fmt.Errorf("folder %v: ignores must be a list", id)
+			}
+			for _, l := range ll {
+				ig = append(ig, fmt.Sprint(l))
+			}
+			delete(f, "ignores")
+		}
+
+		i := p.addObj("folders", id, f, lf[id])
+		if !igSet {
+			continue
+		}
+		cur := []string{}
+		if lf[id] != nil {
+			cur, err = api.GetIgnores(id)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if cur == nil {
+			cur = []string{}
+		}
+		if strings.Join(cur, "\n") == strings.Join(ig, "\n") {
+			continue
+		}
+		if i < 0 {
+			p = append(p, planItem{Op: "~", Kind: "folders", ID: id, Name: objName(f)})
+			i = len(p) - 1
+		}
+		p[i].Ignores = append([]string{}, ig...)
+		p[i].Changes = append(p[i].Changes, change{Path: "ignores", Old: cur, New: ig})
+	}
+
+	if ds.Prune {
+		for _, id := range slices.Sorted(maps.Keys(lf)) {
+			if !wantF[id] {
+				p = append(p, planItem{Op: "-", Kind: "folders", ID: id, Name: objName(lf[id])})
+			}
+		}
+		for _, id := range slices.Sorted(maps.Keys(ld)) {
+			if !want[id] {
+				p = append(p, planItem{Op: "-", Kind: "devices", ID: id, Name: objName(ld[id])})
+			}
+		}
+	}
+	return p, nil
+}
+
+// addObj adds a plan item to create the desired object, or to change the
+// fields of the live one that differ, returning its index or -1 if equal
+func (p *plan) addObj(kind, id string, want, live map[string]interface{}) int {
+	if live == nil {
+		*p = append(*p, planItem{Op: "+", Kind: kind, ID: id, Name: objName(want), Obj: want})
+		return len(*p) - 1
+	}
+	ch := diffJSON("", project(live, want), want)
+	if len(ch) == 0 {
+		return -1
+	}
+	*p = append(*p, planItem{Op: "~", Kind: kind, ID: id, Name: objName(live), Obj: want, Changes: ch})
+	return len(*p) - 1
+}
+
+// folderDevices converts list of device names or IDs to folder device
+// entries, keeping settings of devices already sharing the folder
+func folderDevices(l []interface{}, names map[string]string, myID string, ld map[string]map[string]interface{}, live map[string]interface{}) ([]interface{}, error) {
+	cur := map[string]interface{}{}
+	if live != nil {
+		cl, _ := live["devices"].([]interface{})
+		for _, d := range cl {
+			if m, ok := d.(map[string]interface{}); ok {
+				cur[fmt.Sprint(m["deviceID"])] = m
+			}
+		}
+	}
+	ids := []string{myID}
+	for _, d := range l {
+		s := fmt.Sprint(d)
+		if id, ok := names[s]; ok {
+			s = id
+		} else if ld[s] == nil && api.CheckDeviceID(s) != nil {
+			return nil, fmt.Errorf("unknown device %q", s)
+		}
+		if !slices.Contains(ids, s) {
+			ids = append(ids, s)
+		}
+	}
+	r := []interface{}{}
+	for _, id := range ids {
+		if c, ok := cur[id]; ok {
+			r = append(r, c)
+			continue
+		}
+		r = append(r, map[string]interface{}{"deviceID": id})
+	}
+	return r, nil
+}
+
+func (p plan) print() {
+	if len(p) == 0 {
+		fmt.Println("No changes, live config matches the desired state")
+		return
+	}
+	n := map[string]int{}
+	for _, i := range p {
+		n[i.Op]++
+		fmt.Printf("%v %v %v\n", i.Op, strings.TrimSuffix(i.Kind, "s"), strings.TrimSpace(i.Name+" ("+i.ID+")"))
+		switch i.Op {
+		case "+":
+			for _, k := range slices.Sorted(maps.Keys(i.Obj)) {
+				fmt.Printf("    %v: %v\n", k, jsonStr(i.Obj[k]))
+			}
+			if len(i.Ignores) > 0 {
+				fmt.Printf("    ignores: %v\n", jsonStr(i.Ignores))
+			}
+		case "~":
+			for _, c := range i.Changes {
+				fmt.Printf("    %v\n", c)
+			}
+		}
+	}
+	fmt.Printf("\nPlan: %v to add, %v to change, %v to remove.\n", n["+"], n["~"], n["-"])
+}
+
+// apply makes the changes, devices are added before folders shared with
+// them and removed after
+func (p plan) apply() error {
+	order := func(i planItem) int {
+		switch {
+		case i.Op != "-" && i.Kind == "devices":
+			return 0
+		case i.Op != "-":
+			return 1
+		case i.Kind == "folders":
+			return 2
+		}
+		return 3
+	}
+	sort.SliceStable(p, func(a, b int) bool { return order(p[a]) < order(p[b]) })
+
+	for _, i := range p {
+		var err error
+		switch i.Op {
+		case "+":
+			err = api.PutConfigObject(i.Kind, i.ID, i.Obj)
+		case "~":
+			// only ignores may have changed
+			if i.Obj != nil {
+				err = api.PatchConfigObject(i.Kind, i.ID, i.Obj)
+			}
+		case "-":
+			err = api.DeleteConfigObject(i.Kind, i.ID)
+		}
+		if err == nil && i.Ignores != nil {
+			err = api.SetIgnores(i.ID, i.Ignores)
+		}
+		if err != nil {
+			return fmt.Errorf("%v %v: %w", strings.TrimSuffix(i.Kind, "s"), i.ID, err)
+		}
+		fmt.Printf("%v %v %v done\n", i.Op, strings.TrimSuffix(i.Kind, "s"), i.ID)
+	}
+	return nil
+}
+
+// project returns fields of live object present in want, recursively
+func project(live, want map[string]interface{}) map[string]interface{} {
+	r := map[string]interface{}{}
+	for k, w := range want {
+		l, ok := live[k]
+		if !ok {
+			continue
+		}
+		lm, lok := l.(map[string]interface{})
+		wm, wok := w.(map[string]interface{})
+		if lok && wok {
+			r[k] = project(lm, wm)
+			continue
+		}
+		r[k] = l
+	}
+	return r
+}
+
+func indexObjs(l []map[string]interface{}, key string) map[string]map[string]interface{} {
+	m := map[string]map[string]interface{}{}
+	for _, o := range l {
+		m[fmt.Sprint(o[key])] = o
+	}
+	return m
+}
+
+// jsonCopy normalizes yaml decoded values to the types json would produce
+func jsonCopy(o map[string]interface{}) map[string]interface{} {
+	j, _ := json.Marshal(o)
+	r := map[string]interface{}{}
+	json.Unmarshal(j, &r)
+	return r
+}
+
+func objName(o map[string]interface{}) string {
+	for _, k := range []string{"label", "name"} {
+		if s, ok := o[k].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,167 @@
+// syncthing cli tool - support bundle
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tenox7/stc/api"
+)
+
+func supportBundle(args []string) error {
+	fs := flag.NewFlagSet("support_bundle", flag.ExitOnError)
+	out := fs.String("o", "", "output zip file, default stc-support-<date>.zip")
+	hash := fs.Bool("hash_ids", false, "replace device IDs with hashes, implies --assemble")
+	asm := fs.Bool("assemble", false, "always assemble the bundle, even if syncthing can make one")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = "stc-support-" + time.Now().Format("20060102-150405") + ".zip"
+	}
+
+	var b []byte
+	if !*asm && !*hash {
+		var err error
+		b, err = api.GetRaw("debug/support")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Syncthing support bundle not available, assembling one: %v\n", err)
+		}
+	}
+	if b == nil {
+		var err error
+		if b, err = assembleBundle(*hash); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(*out, b, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Support bundle written to %v\n", *out)
+	return nil
+}
+
+// assembleBundle collects status, redacted config and logs into a zip,
+// failures are listed in errors.txt instead of stopping the collection
+func assembleBundle(hashIDs bool) ([]byte, error) {
+	files := map[string][]byte{}
+	errs := []string{}
+	get := func(name, path string) {
+		b, err := api.GetRaw(path)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", name, err))
+			return
+		}
+		files[name] = b
+	}
+	get("version.json", "system/version")
+	get("system_status.json", "system/status")
+	get("system_errors.json", "system/error")
+	get("connections.json", "system/connections")
+	get("log.json", "system/log")
+	get("log.txt", "system/log.txt")
+
+	ids := []string{}
+	if b, err := api.GetConfigRaw(); err != nil {
+		errs = append(errs, fmt.Sprintf("config.json: %v", err))
+	} else if c, err := redactConfig(b); err != nil {
+		errs = append(errs, fmt.Sprintf("config.json: %v", err))
+	} else {
+		files["config.json"] = c
+	}
+	if cfg, err := api.GetConfig(); err == nil {
+		fe := map[string]interface{}{}
+		for _, f := range cfg.Folders {
+			e, err := api.GetFolderErrors(f.ID)
+			if err != nil {
+				fe[f.ID] = err.Error()
+				continue
+			}
+			fe[f.ID] = e.Errors
+		}
+		files["folder_errors.json"], _ = json.MarshalIndent(fe, "", "  ")
+		for _, d := range cfg.Devices {
+			ids = append(ids, d.DeviceID)
+		}
+	}
+	if s, err := getSnapshot(); err != nil {
+		errs = append(errs, fmt.Sprintf("status.json: %v", err))
+	} else {
+		files["status.json"], _ = json.MarshalIndent(struct {
+			Folders []SyncFolder `json:"folders"`
+			Devices []SyncDevice `json:"devices"`
+		}{s.Folders, s.Devices}, "", "  ")
+	}
+	if len(errs) > 0 {
+		files["errors.txt"] = []byte(strings.Join(errs, "\n") + "\n")
+	}
+
+	// device ids appear in many places, also as short ids in logs
+	var rp *strings.Replacer
+	if hashIDs {
+		r := []string{}
+		for _, id := range ids {
+			h := sha256.Sum256([]byte(id))
+			hs := "DEVICE-" + strings.ToUpper(hex.EncodeToString(h[:6]))
+			r = append(r, id, hs)
+			if len(id) > 7 {
+				r = append(r, id[:7], hs)
+			}
+		}
+		rp = strings.NewReplacer(r...)
+	}
+
+	o := &bytes.Buffer{}
+	z := zip.NewWriter(o)
+	for n, b := range files {
+		if rp != nil {
+			b = []byte(rp.Replace(string(b)))
+		}
+		w, err := z.CreateHeader(&zip.FileHeader{Name: n, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return o.Bytes(), nil
+}
+
+// redactConfig replaces secrets in config json
+func redactConfig(b []byte) ([]byte, error) {
+	v, err := decodeCfg(b)
+	if err != nil {
+		return nil, err
+	}
+	var redact func(v interface{})
+	redact = func(v interface{}) {
+		switch vv := v.(type) {
+		case map[string]interface{}:
+			for k, e := range vv {
+				if s, ok := e.(string); ok && secretKeys[k] && s != "" {
+					vv[k] = "REDACTED"
+					continue
+				}
+				redact(e)
+			}
+		case []interface{}:
+			for _, e := range vv {
+				redact(e)
+			}
+		}
+	}
+	redact(v)
+	return json.MarshalIndent(v, "", "  ")
+}
@@ -0,0 +1,317 @@
+// syncthing cli tool - config backup, restore and diff
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/tenox7/stc/api"
+)
+
+// change is a single difference between two configs, Old is unset for added
+// and New for removed values
+type change struct {
+	Path    string
+	Old     interface{}
+	New     interface{}
+	Added   bool
+	Removed bool
+}
+
+// keys identifying objects in config arrays, so that they are compared by
+// identity rather than position
+var idKeys = []string{"id", "deviceID"}
+
+// secret values are never printed
+var secretKeys = map[string]bool{"apiKey": true, "password": true, "encryptionPassword": true}
+
+func configExport() error {
+	b, err := api.GetConfigRaw()
+	if err != nil {
+		return err
+	}
+	o := &bytes.Buffer{}
+	if err := json.Indent(o, b, "", "  "); err != nil {
+		return err
+	}
+	fmt.Println(o.String())
+	return nil
+}
+
+func configImport(args []string) error {
+	fs := flag.NewFlagSet("config_import", flag.ExitOnError)
+	yes, dry := guardFlags(fs)
+	pos, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return fmt.Errorf("usage: config_import <file.json> [--yes] [--dry_run]")
+	}
+
+	b, err := os.ReadFile(pos[0])
+	if err != nil {
+		return err
+	}
+	nw, err := decodeCfg(b)
+	if err != nil {
+		return fmt.Errorf("%v: %w", pos[0], err)
+	}
+	lb, err := api.GetConfigRaw()
+	if err != nil {
+		return err
+	}
+	lv, err := decodeCfg(lb)
+	if err != nil {
+		return err
+	}
+
+	ch := diffJSON("", lv, nw)
+	printChanges(ch)
+	if len(ch) == 0 {
+		return nil
+	}
+	ok, err := guard(fmt.Sprintf("replace the running config with %v", pos[0]), *yes, *dry)
+	if !ok {
+		return err
+	}
+
+	if err := api.PutConfig(b); err != nil {
+		return err
+	}
+	rr, err := api.RestartRequired()
+	if err != nil {
+		return err
+	}
+	if rr {
+		fmt.Println("Config applied, syncthing restart is required")
+	}
+	return nil
+}
+
+// configDiff compares two config sources, an empty source is the live
+// config of the current target
+func configDiff(a, b string) error {
+	ac, err := cfgSource(a)
+	if err != nil {
+		return err
+	}
+	bc, err := cfgSource(b)
+	if err != nil {
+		return err
+	}
+	printChanges(diffJSON("", ac, bc))
+	return nil
+}
+
+// cfgSource loads config json from a file, from a host given by profile
+// name, or from the current target if empty
+func cfgSource(s string) (interface{}, error) {
+	if s == "" {
+		b, err := api.GetConfigRaw()
+		if err != nil {
+			return nil, err
+		}
+		return decodeCfg(b)
+	}
+	if _, err := os.Stat(s); err == nil {
+		b, err := os.ReadFile(s)
+		if err != nil {
+			return nil, err
+		}
+		return decodeCfg(b)
+	}
+	pr, err := loadProfiles()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := pr[s]; !ok {
+		return nil, fmt.Errorf("%q is neither a file nor a profile", s)
+	}
+
+	// each host needs its own connection, simplest is a separate stc
+	ex, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(ex, "-profile", s, "config_export")
+	cmd.Stderr = os.Stderr
+	b, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("profile %v: %w", s, err)
+	}
+	return decodeCfg(b)
+}
+
+func decodeCfg(b []byte) (interface{}, error) {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, ok := v.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("config must be a json object")
+	}
+	return v, nil
+}
+
+// diffJSON compares decoded json values, arrays of objects are matched by
+// their ID and arrays of values are compared as sets
+func diffJSON(path string, a, b interface{}) []change {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		ch := []change{}
+		for _, k := range unionKeys(av, bv) {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			x, xok := av[k]
+			y, yok := bv[k]
+			switch {
+			case !xok:
+				ch = append(ch, change{Path: p, New: y, Added: true})
+			case !yok:
+				ch = append(ch, change{Path: p, Old: x, Removed: true})
+			default:
+				ch = append(ch, diffJSON(p, x, y)...)
+			}
+		}
+		return ch
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		ai, aok := indexByID(av)
+		bi, bok := indexByID(bv)
+		if aok && bok {
+			return diffJSON(path, ai, bi)
+		}
+		if sameSet(av, bv) {
+			return nil
+		}
+	}
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	return []change{{Path: path, Old: a, New: b}}
+}
+
+// indexByID converts array of objects to a map keyed by [id], reporting
+// false if any of the elements has no id
+func indexByID(l []interface{}) (map[string]interface{}, bool) {
+	m := map[string]interface{}{}
+	for _, e := range l {
+		o, ok := e.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		id := ""
+		for _, k := range idKeys {
+			if s, ok := o[k].(string); ok {
+				id = s
+				break
+			}
+		}
+		if id == "" {
+			return nil, false
+		}
+		m["["+id+"]"] = o
+	}
+	return m, true
+}
+
+func sameSet(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	s := func(l []interface{}) []string {
+		r := []string{}
+		for _, e := range l {
+			j, _ := json.Marshal(e)
+			r = append(r, string(j))
+		}
+		sort.Strings(r)
+		return r
+	}
+	return reflect.DeepEqual(s(a), s(b))
+}
+
+func unionKeys(a, b map[string]interface{}) []string {
+	k := slices.Collect(maps.Keys(a))
+	for n := range b {
+		if _, ok := a[n]; !ok {
+			k = append(k, n)
+		}
+	}
+	sort.Strings(k)
+	return k
+}
+
+func printChanges(ch []change) {
+	if len(ch) == 0 {
+		fmt.Println("No differences")
+		return
+	}
+	for _, c := range ch {
+		fmt.Println(c)
+	}
+	fmt.Printf("%v changes\n", len(ch))
+}
+
+func (c change) String() string {
+	p := strings.ReplaceAll(c.Path, ".[", "[")
+	switch {
+	case c.Added:
+		return "+ " + p + showVal(p, c.New)
+	case c.Removed:
+		return "- " + p + showVal(p, c.Old)
+	case secretKeys[lastKey(p)]:
+		return "~ " + p + ": (changed)"
+	}
+	return "~ " + p + ": " + jsonStr(c.Old) + " -> " + jsonStr(c.New)
+}
+
+// showVal describes an added or removed value, objects by their name
+func showVal(path string, v interface{}) string {
+	if secretKeys[lastKey(path)] {
+		return ""
+	}
+	o, ok := v.(map[string]interface{})
+	if !ok || !strings.HasSuffix(path, "]") {
+		return ": " + jsonStr(v)
+	}
+	for _, k := range []string{"label", "name"} {
+		if s, ok := o[k].(string); ok && s != "" {
+			return " (" + s + ")"
+		}
+	}
+	return ""
+}
+
+func lastKey(p string) string {
+	return p[strings.LastIndex(p, ".")+1:]
+}
+
+func jsonStr(v interface{}) string {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(j)
+}
@@ -0,0 +1,93 @@
+// syncthing cli tool - debug facilities
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tenox7/stc/api"
+)
+
+func dumpDebug() error {
+	d, err := api.GetDebug()
+	if err != nil {
+		return err
+	}
+	t := tabwriter.NewWriter(os.Stdout, 9, 0, 2, ' ', tabwriter.TabIndent)
+	fmt.Fprintf(t, "Facility\tEnabled\tDescription\n")
+	for _, f := range slices.Sorted(maps.Keys(d.Facilities)) {
+		fmt.Fprintf(t, "%v\t%v\t%v\n", f, slices.Contains(d.Enabled, f), d.Facilities[f])
+	}
+	t.Flush()
+	return nil
+}
+
+// debugSet enables or disables facilities, with --for enabled ones are
+// disabled again after the time passes, on interrupt or when the terminal
+// is closed
+func debugSet(enable bool, args []string) error {
+	fs := flag.NewFlagSet("debug_disable", flag.ExitOnError)
+	usage := "usage: debug_disable <facility,...>"
+	dur := new(time.Duration)
+	if enable {
+		fs = flag.NewFlagSet("debug_enable", flag.ExitOnError)
+		usage = "usage: debug_enable <facility,...> [--for 10m]"
+		dur = fs.Duration("for", 0, "disable again after this time, eg. 10m")
+	}
+	pos, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return errors.New(usage)
+	}
+	d, err := api.GetDebug()
+	if err != nil {
+		return err
+	}
+	fl := strings.Split(pos[0], ",")
+	for _, f := range fl {
+		if _, ok := d.Facilities[f]; !ok {
+			return fmt.Errorf("unknown debug facility %q, see stc debug", f)
+		}
+	}
+	if !enable {
+		return api.SetDebug("", pos[0])
+	}
+
+	if err := api.SetDebug(pos[0], ""); err != nil {
+		return err
+	}
+	if *dur <= 0 {
+		return nil
+	}
+
+	// facilities enabled before are left alone
+	off := []string{}
+	for _, f := range fl {
+		if !slices.Contains(d.Enabled, f) {
+			off = append(off, f)
+		}
+	}
+	if len(off) == 0 {
+		return nil
+	}
+	fmt.Printf("Debug enabled for %v, until %v, interrupt to disable now\n", strings.Join(off, ","), time.Now().Add(*dur).Format("15:04:05"))
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	select {
+	case <-sig:
+	case <-time.After(*dur):
+	}
+	fmt.Printf("Disabling debug for %v\n", strings.Join(off, ","))
+	return api.SetDebug("", strings.Join(off, ","))
+}
@@ -0,0 +1,221 @@
+// syncthing cli tool - device connection diagnostics
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/tenox7/stc/api"
+)
+
+// diagOpts are the options deciding how devices find and reach each other
+type diagOpts struct {
+	GlobalAnnounce bool `json:"globalAnnounceEnabled"`
+	LocalAnnounce  bool `json:"localAnnounceEnabled"`
+	Relays         bool `json:"relaysEnabled"`
+	NAT            bool `json:"natEnabled"`
+}
+
+// diagnose shows what this device knows about reaching dName and guesses
+// why it is not connected
+func diagnose(dName string) error {
+	d, err := resolveDevice(dName)
+	if err != nil {
+		return err
+	}
+	cfg, err := api.GetConfig()
+	if err != nil {
+		return err
+	}
+	addrs := []string{}
+	for _, cd := range cfg.Devices {
+		if cd.DeviceID == d.ID {
+			addrs = cd.Addresses
+		}
+	}
+	st, err := api.GetSysStatus()
+	if err != nil {
+		return err
+	}
+	co, err := api.GetConnection()
+	if err != nil {
+		return err
+	}
+	disc, err := api.GetDiscovery()
+	if err != nil {
+		return err
+	}
+	ds, err := api.GetDevStats()
+	if err != nil {
+		return err
+	}
+	b, err := api.GetOptionsRaw()
+	if err != nil {
+		return err
+	}
+	o := diagOpts{}
+	if err := json.Unmarshal(b, &o); err != nil {
+		return err
+	}
+
+	c := co[d.ID]
+	seen := ds[d.ID].LastSeen
+	t := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(t, "Device:\t%v\n", d)
+	switch {
+	case d.ID == st.MyID:
+		fmt.Fprintf(t, "Status:\tthis device\n")
+	case d.Paused || c.Paused:
+		fmt.Fprintf(t, "Status:\tpaused\n")
+	case c.Connected:
+		fmt.Fprintf(t, "Status:\tconnected %v\n", c.ClientVersion)
+		fmt.Fprintf(t, "Connection:\t%v %v, %v, %v, %v\n", c.Type, c.Address, c.Crypto, lanStr(c.IsLocal), viaStr(c.Type))
+	default:
+		fmt.Fprintf(t, "Status:\tnot connected\n")
+	}
+	fmt.Fprintf(t, "Configured:\t%v\n", listStr(addrs))
+	fmt.Fprintf(t, "Discovered:\t%v\n", listStr(disc[d.ID].Addresses))
+	if seen.Year() <= 1970 {
+		fmt.Fprintf(t, "Last seen:\tnever\n")
+	} else {
+		fmt.Fprintf(t, "Last seen:\t%v (%v)\n", humanize.Time(seen), seen.Local().Format(time.DateTime))
+	}
+
+	fmt.Fprintf(t, "\t\nThis device\t\n")
+	ls := []string{}
+	for l := range st.ConnectionServiceStatus {
+		ls = append(ls, l)
+	}
+	sort.Strings(ls)
+	for _, l := range ls {
+		s := st.ConnectionServiceStatus[l]
+		if s.Error != nil && *s.Error != "" {
+			fmt.Fprintf(t, "Listener:\t%v error: %v\n", l, *s.Error)
+			continue
+		}
+		fmt.Fprintf(t, "Listener:\t%v LAN %v, WAN %v\n", l, listStr(s.LANAddresses), listStr(s.WANAddresses))
+	}
+	dl := []string{}
+	for s := range st.DiscoveryStatus {
+		dl = append(dl, s)
+	}
+	sort.Strings(dl)
+	for _, s := range dl {
+		e := st.DiscoveryStatus[s].Error
+		if e != nil && *e != "" {
+			fmt.Fprintf(t, "Discovery:\t%v error: %v\n", s, *e)
+			continue
+		}
+		fmt.Fprintf(t, "Discovery:\t%v OK\n", s)
+	}
+	fmt.Fprintf(t, "Announce:\tglobal %v, local %v\n", onStr(o.GlobalAnnounce), onStr(o.LocalAnnounce))
+	fmt.Fprintf(t, "Relays:\t%v\n", onStr(o.Relays))
+	fmt.Fprintf(t, "NAT traversal:\t%v\n", onStr(o.NAT))
+	t.Flush()
+
+	fmt.Println("\nDiagnosis:")
+	for _, r := range diagReasons(d, st, c.Connected, c.Type, addrs, disc[d.ID].Addresses, seen, o) {
+		fmt.Printf("  - %v\n", r)
+	}
+	return nil
+}
+
+// diagReasons lists the likely causes of a missing or poor connection,
+// most likely first
+func diagReasons(d cfgObj, st api.SysStatus, conn bool, ty string, addrs, disc []string, seen time.Time, o diagOpts) []string {
+	if d.ID == st.MyID {
+		return []string{"this is the local device"}
+	}
+	if d.Paused {
+		return []string{"the device is paused in the config of this device"}
+	}
+
+	wan, lerr := false, []string{}
+	for l, s := range st.ConnectionServiceStatus {
+		if s.Error != nil && *s.Error != "" {
+			lerr = append(lerr, fmt.Sprintf("listener %v fails (%v), the device can't connect to us", l, *s.Error))
+		}
+		wan = wan || len(s.WANAddresses) > 0
+	}
+	sort.Strings(lerr)
+	if conn {
+		if strings.HasPrefix(ty, "relay") {
+			r := []string{"connected through a relay, which is slow, a direct connection failed on both sides, check port forwarding and firewalls"}
+			if !wan {
+				r = append(r, "no WAN address was found by NAT traversal, forward the listen port to this device")
+			}
+			return append(r, lerr...)
+		}
+		return []string{"connected directly, no problem found"}
+	}
+
+	r := []string{}
+	static := slices.DeleteFunc(slices.Clone(addrs), func(a string) bool { return a == "dynamic" })
+	derr := []string{}
+	for s, e := range st.DiscoveryStatus {
+		if e.Error != nil && *e.Error != "" {
+			derr = append(derr, s)
+		}
+	}
+	sort.Strings(derr)
+	switch {
+	case len(static)+len(disc) > 0:
+		r = append(r, fmt.Sprintf("the device can't be reached on %v, it is offline, an address is wrong or a firewall or NAT blocks it", listStr(slices.Concat(static, disc))))
+	case !o.GlobalAnnounce && !o.LocalAnnounce:
+		r = append(r, "discovery is disabled, so the dynamic address can't be looked up, add a static address or enable discovery")
+	case len(derr) > 0 && len(derr) == len(st.DiscoveryStatus):
+		r = append(r, fmt.Sprintf("all discovery fails (%v), so the dynamic address can't be looked up", strings.Join(derr, ", ")))
+	default:
+		r = append(r, "no discovery knows an address of the device, it is likely not running or offline, or has announcing disabled")
+	}
+	if seen.Year() <= 1970 {
+		r = append(r, fmt.Sprintf("the device was never connected, check this device %v is added on the other side", st.MyID))
+	} else {
+		r = append(r, fmt.Sprintf("the device was last seen %v", humanize.Time(seen)))
+	}
+	if !o.Relays {
+		r = append(r, "relays are disabled, only a direct connection is possible")
+	}
+	if len(derr) > 0 && len(derr) < len(st.DiscoveryStatus) {
+		r = append(r, fmt.Sprintf("some discovery fails: %v", strings.Join(derr, ", ")))
+	}
+	if o.NAT && !wan {
+		r = append(r, "no WAN address was found by NAT traversal, the device can only reach us on LAN or through a relay")
+	}
+	return append(r, lerr...)
+}
+
+func listStr(l []string) string {
+	if len(l) == 0 {
+		return "none"
+	}
+	return strings.Join(l, ", ")
+}
+
+func onStr(b bool) string {
+	if b {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+func lanStr(l bool) string {
+	if l {
+		return "LAN"
+	}
+	return "WAN"
+}
+
+func viaStr(ty string) string {
+	if strings.HasPrefix(ty, "relay") {
+		return "relayed"
+	}
+	return "direct"
+}
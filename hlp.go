@@ -2,14 +2,22 @@
 package main
 
 import (
-	"encoding/xml"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/tenox7/stc/api"
+	"golang.org/x/term"
 )
 
 func usage() {
@@ -17,23 +25,72 @@ func usage() {
 	fmt.Fprintf(o, "stc [flags] [commands]\n\nflags:\n")
 	flag.PrintDefaults()
 	fmt.Fprintf(o, "\ncommands:\n"+
-		"  log            - print syncthing 'recent' log\n"+
-		"  restart        - restart syncthing daemon\n"+
-		"  shutdown       - shutdown syncthing daemon\n"+
+		"  log [--level warn] [--since 10m] [--grep re] [--follow] [--txt]\n"+
+		"                 - print syncthing recent log, --txt for log.txt verbatim\n"+
+		"  debug          - list debug facilities and whether they are enabled\n"+
+		"  debug_enable <facility,...> [--for 10m]\n"+
+		"                 - enable debug logging, with --for disable it again after the time\n"+
+		"  debug_disable <facility,...>\n"+
+		"                 - disable debug logging\n"+
+		"  support_bundle [-o bundle.zip] [--hash_ids] [--assemble]\n"+
+		"                 - save syncthing support bundle, or one with status, redacted config and logs\n"+
+		"  upgrade_check  - show running and latest syncthing version\n"+
+		"  upgrade [--yes]\n"+
+		"                 - upgrade syncthing, wait for it to restart and show the new version\n"+
+		"  restart [--wait] - restart syncthing daemon, wait until it is back and folders are scanned\n"+
+		"  shutdown [--wait] - shutdown syncthing daemon, wait until it stops answering *\n"+
 		"  errors         - print errors visible in web UI\n"+
 		"  clear_errors   - clear errors in the web UI\n"+
 		"  post_error     - posts a custom error message in the web UI\n"+
 		"  folder_errors  - prints folder errors from scan or pull\n"+
 		"  folder_pause   - pause specified folder\n"+
 		"  folder_resume  - unpause specified folder\n"+
+		"  folder_remove  - remove specified folder from config, files are kept *\n"+
+		"  diagnose <device> - show addresses and connection details of a device and why it may not connect\n"+
 		"  id             - print ID of this node\n"+
-		"  reset_db       - reset the database / file index\n"+
-		"  rescan         - rescan a folder or 'all'\n"+
-		"  override       - override remote changed for a send-only folder (OoSync)\n"+
-		"  revert         - revert local changes for a receive-only folder (LocAdds)\n"+
+		"  reset_db [folder] - reset the database / file index of a folder or all folders *\n"+
+		"  rescan <folder|all> [--sub path]... [--delay 60s] [--wait]\n"+
+		"                 - rescan a folder or 'all', --sub only scans paths within the folder,\n"+
+		"                   --delay postpones the next full scan, --wait waits until scans are done\n"+
+		"  prioritize <folder> <path|glob>...\n"+
+		"                 - pull these files first, globs match files the folder needs, shows the queue\n"+
+		"  override       - override remote changed for a send-only folder (OoSync) *\n"+
+		"  revert         - revert local changes for a receive-only folder (LocAdds) *\n"+
+		"                   * commands ask for confirmation, --yes skips it, --dry_run only describes them\n"+
 		"  events [types] - prints a json list of latest events, [types] is a comma-delimited list of events\n"+
 		"                   see https://docs.syncthing.net/dev/events.html#event-types for a list of event types\n"+
-		"  json_dump      - prints a json object with device and folder info, for easier parsing in scripts\n",
+		"  json_dump      - prints a json object with device and folder info, for easier parsing in scripts\n"+
+		"  config_export  - print the full config as json\n"+
+		"  config_import <file> [--yes] [--dry_run]\n"+
+		"                 - show differences and replace the config with json from file *\n"+
+		"  config_diff <file|profile> [file|profile]\n"+
+		"                 - compare configs by folder and device ID, with live config if one given\n"+
+		"  apply -f <desired.yaml> [--auto_approve]\n"+
+		"                 - show plan to converge folders and devices to desired state and apply it\n"+
+		"  options        - print global options\n"+
+		"  options_set <key=value> ...\n"+
+		"                 - change global options, lists are comma separated\n"+
+		"  ratelimit [--send rate] [--recv rate]\n"+
+		"                 - show or set global rate limits, eg. 500K, 5M, 0 for unlimited\n"+
+		"  scheduler --rules <rules.yaml> [--once]\n"+
+		"                 - apply time of day rate limits, options and pauses, continuously or once\n"+
+		"  notify [--on events] [--webhook url] [--exec cmd] [--smtp host:port] [--test]\n"+
+		"                 - send notifications on folder errors, offline devices, completed sync and errors\n"+
+		"  agent --config <agent.yaml>\n"+
+		"                 - run notify, scheduler and prometheus metrics together as a service\n"+
+		"  offline_config [folders|devices|json|validate] [--show_secrets]\n"+
+		"                 - inspect or validate config.xml directly, without running syncthing\n"+
+		"  apikey_store [target]\n"+
+		"                 - store API Key read from stdin in OS keyring for the target\n"+
+		"  apikey_delete [target]\n"+
+		"                 - delete API Key stored for the target\n"+
+		"  profile list   - list connection profiles from stc config file\n"+
+		"  profile add <name> key=value ...\n"+
+		"                 - create or replace a profile, keys are flag names or apikey_command\n"+
+		"  profile remove <name>\n"+
+		"                 - remove a profile\n"+
+		"  profile test [name]\n"+
+		"                 - check connection using a profile\n",
 	)
 }
 
@@ -44,9 +101,12 @@ func printVer() {
 // cfg determines the final API key and target URL.
 // The order of precedence for each value is:
 // 1. Command-line flag (e.g., -apikey, -target)
-// 2. Environment variables
-// 3. Value from config.xml
-func cfg(apiKeyFlag, targetFlag, homeDirFlag string) (string, string, error) {
+// 2. API key from -apikey_file or output of -apikey_command
+// 3. Environment variables
+// 4. API key stored in keyring for the target
+// 5. Value from config.xml
+// The API key may be left empty when GUI user authentication is used.
+func cfg(apiKeyFlag, apiKeyFile, apiKeyCmd, targetFlag, homeDirFlag string, haveUser bool) (string, string, error) {
 	// 1. Start by loading values from the config file to establish a baseline.
 	apiKeyFromFile, targetFromFile, cfgErr := readConfigXML(homeDirFlag)
 
@@ -65,19 +125,48 @@ func cfg(apiKeyFlag, targetFlag, homeDirFlag string) (string, string, error) {
 	}
 
 	// 4. Override with command-line flags, as they have the highest precedence.
-	if apiKeyFlag != "" {
-		finalAPIKey = apiKeyFlag
-	}
 	if targetFlag != "" {
 		finalTarget = targetFlag
 	}
+	switch {
+	case apiKeyFlag != "":
+		finalAPIKey = apiKeyFlag
+	case apiKeyFile != "":
+		k, err := readKeyFlagFile(apiKeyFile)
+		if err != nil {
+			return "", "", fmt.Errorf("apikey_file: %w", err)
+		}
+		finalAPIKey = k
+	case apiKeyCmd != "":
+		k, err := secretCmd(apiKeyCmd)
+		if err != nil {
+			return "", "", fmt.Errorf("apikey_command: %w", err)
+		}
+		finalAPIKey = k
+	case os.Getenv("STC_APIKEY") == "" && os.Getenv("APIKEY") == "" && finalTarget != "" && !haveUser:
+		// Key stored in keyring takes precedence over config.xml only,
+		// if it can't be read config.xml is still tried. A GUI user
+		// doesn't use it.
+		k, err := keyGet(finalTarget)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Stored API Key not available: %v\n", err)
+		}
+		if k != "" {
+			finalAPIKey = k
+		}
+	}
+
+	// A GUI user replaces an API key that only came from config.xml.
+	if haveUser && finalAPIKey == apiKeyFromFile {
+		finalAPIKey = ""
+	}
 
 	// 5. After checking all sources, validate that we have the required values.
-	if finalAPIKey == "" {
+	if finalAPIKey == "" && !haveUser {
 		if cfgErr != nil && !errors.Is(cfgErr, os.ErrNotExist) {
 			return "", "", fmt.Errorf("API key not found and config file invalid: %w", cfgErr)
 		}
-		return "", "", fmt.Errorf("API key not found. Provide it via -apikey flag, STC_APIKEY env var, or config.xml")
+		return "", "", fmt.Errorf("API key not found. Provide it via -apikey, -apikey_file or -apikey_command flag, STC_APIKEY env var, apikey_store command, or config.xml")
 	}
 
 	if finalTarget == "" {
@@ -93,33 +182,28 @@ func cfg(apiKeyFlag, targetFlag, homeDirFlag string) (string, string, error) {
 // readConfigXML finds and parses the Syncthing config.xml to extract the API
 // key and target URL. It returns os.ErrNotExist if the file cannot be found.
 func readConfigXML(homeDir string) (string, string, error) {
-	cfgFile, err := findCfgFile(homeDir)
+	f, cfgFile, err := homeFile(homeDir, "config.xml")
 	if err != nil {
 		// Pass the error up, which will likely be os.ErrNotExist
 		return "", "", err
 	}
 
-	f, err := os.ReadFile(cfgFile)
+	x, err := api.ParseConfigXML(f)
 	if err != nil {
-		return "", "", fmt.Errorf("could not read %s: %w", cfgFile, err)
-	}
-
-	x := struct {
-		XMLName xml.Name `xml:"configuration"`
-		GUI     struct {
-			ApiKey  string `xml:"apikey"`
-			Address string `xml:"address"`
-			UseTLS  bool   `xml:"tls,attr"`
-		} `xml:"gui"`
-	}{}
-
-	if err = xml.Unmarshal(f, &x); err != nil {
 		return "", "", fmt.Errorf("could not parse %s: %w", cfgFile, err)
 	}
 
 	// If address is empty in XML return empty
 	if x.GUI.Address == "" {
-		return x.GUI.ApiKey, "", nil
+		return x.GUI.APIKey, "", nil
+	}
+
+	// GUI listening on a unix domain socket
+	if strings.HasPrefix(x.GUI.Address, "unix://") {
+		return x.GUI.APIKey, x.GUI.Address, nil
+	}
+	if strings.HasPrefix(x.GUI.Address, "/") {
+		return x.GUI.APIKey, "unix://" + x.GUI.Address, nil
 	}
 
 	scheme := "http://"
@@ -127,7 +211,26 @@ func readConfigXML(homeDir string) (string, string, error) {
 		scheme = "https://"
 	}
 
-	return x.GUI.ApiKey, scheme + x.GUI.Address, nil
+	return x.GUI.APIKey, scheme + x.GUI.Address, nil
+}
+
+// homeFile reads a file from the Syncthing home directory, the one containing
+// config.xml, on the local machine or on the ssh remote host if connected.
+// It returns contents and path of the file.
+func homeFile(homeDir, name string) ([]byte, string, error) {
+	if sshc != nil {
+		return sshHomeFile(homeDir, name)
+	}
+	cfgFile, err := findCfgFile(homeDir)
+	if err != nil {
+		return nil, "", err
+	}
+	path := filepath.Join(filepath.Dir(cfgFile), name)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read %s: %w", path, err)
+	}
+	return b, path, nil
 }
 
 // findCfgFile searches for config.xml in standard Syncthing locations.
@@ -179,6 +282,94 @@ func findCfgFile(homeDir string) (string, error) {
 	return "", fmt.Errorf("config.xml not found in any standard location: %w", os.ErrNotExist)
 }
 
+// tlsSetup configures trust of https server certificates and the client
+// certificate. Syncthing self-signed https-cert.pem from the home directory
+// is always trusted.
+func tlsSetup(homeDir, target, caFile, pin, certFile, keyFile string) error {
+	// no local syncthing is fine, a broken certificate is not when the
+	// target is the syncthing it belongs to
+	p, f, err := homeFile(homeDir, "https-cert.pem")
+	if err == nil {
+		if err = api.TrustCert(p); err != nil {
+			err = fmt.Errorf("%v: %w", f, err)
+		}
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		if _, ht, _ := readConfigXML(homeDir); ht == target {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	if caFile != "" {
+		p, err := os.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		if err := api.AddRootCA(p); err != nil {
+			return fmt.Errorf("%v: %w", caFile, err)
+		}
+	}
+	if pin != "" {
+		if err := api.SetPins(pin); err != nil {
+			return err
+		}
+	}
+	if certFile == "" {
+		if keyFile != "" {
+			return fmt.Errorf("client_key specified without client_cert")
+		}
+		return nil
+	}
+	if keyFile == "" {
+		keyFile = certFile
+	}
+	return api.SetClientCert(certFile, keyFile)
+}
+
+// secretCmd runs a shell command printing a secret, such as a password
+// manager lookup, and returns its output
+func secretCmd(c string) (string, error) {
+	cmd := shellCmd(c)
+	cmd.Stderr = os.Stderr
+	o, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(o)), nil
+}
+
+// shellCmd runs c with the system shell
+func shellCmd(c string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", c)
+	}
+	return exec.Command("sh", "-c", c)
+}
+
+// creds returns GUI user and password from flags or STC_USER and
+// STC_PASSWORD environment variables
+func creds(userFlag, passFlag string) (string, string) {
+	u, p := os.Getenv("STC_USER"), os.Getenv("STC_PASSWORD")
+	if userFlag != "" {
+		u = userFlag
+	}
+	if passFlag != "" {
+		p = passFlag
+	}
+	return u, p
+}
+
+// sessionFile returns path of the GUI session cookie cache for a user and
+// target, or empty string if there is no user cache directory
+func sessionFile(user, target string) string {
+	d, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	h := sha256.Sum256([]byte(user + "@" + target))
+	return filepath.Join(d, "stc", "session-"+hex.EncodeToString(h[:8])+".json")
+}
+
 func isConn(paused, conn bool, ID, myID string) string {
 	if ID == myID {
 		return "Myself"
@@ -208,17 +399,140 @@ func fStatus(paused bool, ty, st string, err, loChg, needItms uint64) string {
 	return st
 }
 
-func folderID(fName string) (string, error) {
+// myName returns name of this device, or its ID if not named
+func myName() (string, error) {
+	st, err := api.GetSysStatus()
+	if err != nil {
+		return "", err
+	}
 	cfg, err := api.GetConfig()
 	if err != nil {
 		return "", err
 	}
-	fID := ""
-	for _, f := range cfg.Folders {
-		if f.Label != fName {
+	for _, d := range cfg.Devices {
+		if d.DeviceID == st.MyID && d.Name != "" {
+			return d.Name, nil
+		}
+	}
+	return st.MyID, nil
+}
+
+// waitRestart waits until syncthing answers again and was started after
+// the given status, by its start time or by lower uptime on old versions
+func waitRestart(before api.SysStatus, timeout time.Duration) (api.SysStatus, error) {
+	end := time.Now().Add(timeout)
+	for time.Now().Before(end) {
+		time.Sleep(time.Second)
+		if api.Ping() != nil {
 			continue
 		}
-		fID = f.ID
+		st, err := api.GetSysStatus()
+		if err != nil {
+			continue
+		}
+		if st.StartTime.After(before.StartTime) || (st.StartTime.IsZero() && st.Uptime < before.Uptime) {
+			return st, nil
+		}
+	}
+	return api.SysStatus{}, fmt.Errorf("syncthing did not restart in %v", timeout)
+}
+
+// parallel calls fn for every index in [0, count) using at most n workers.
+// No more work is handed out after the first error, which is returned once
+// the running calls finish.
+func parallel(n, count int, fn func(i int) error) error {
+	if n < 1 {
+		n = 1
+	}
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+		fErr error
+	)
+	idx := make(chan int)
+	stop := make(chan struct{})
+	for w := 0; w < n && w < count; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				if err := fn(i); err != nil {
+					once.Do(func() {
+						fErr = err
+						close(stop)
+					})
+				}
+			}
+		}()
+	}
+feed:
+	for i := 0; i < count; i++ {
+		select {
+		case <-stop:
+			break feed
+		default:
+		}
+		select {
+		case idx <- i:
+		case <-stop:
+			break feed
+		}
+	}
+	close(idx)
+	wg.Wait()
+	return fErr
+}
+
+// parseArgs parses command specific flags which may appear before, after or
+// between positional arguments, returning the positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	pos := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return pos, nil
+		}
+		pos = append(pos, args[0])
+		args = args[1:]
+	}
+}
+
+// guardFlags adds --yes and --dry_run to a destructive command
+func guardFlags(fs *flag.FlagSet) (yes, dry *bool) {
+	yes = fs.Bool("yes", false, "don't ask for confirmation")
+	dry = fs.Bool("dry_run", false, "only describe what would happen")
+	return yes, dry
+}
+
+// guard describes a destructive action and returns whether to carry it out.
+// On a terminal it asks, otherwise --yes is required.
+func guard(what string, yes, dry bool) (bool, error) {
+	if dry {
+		fmt.Printf("Would %v\n", what)
+		return false, nil
+	}
+	if yes {
+		return true, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false, fmt.Errorf("not on a terminal, use --yes to %v", what)
+	}
+	if !confirm(strings.ToUpper(what[:1]) + what[1:] + "?") {
+		return false, fmt.Errorf("cancelled")
+	}
+	return true, nil
+}
+
+// confirm asks a yes/no question on the terminal, returning false if the
+// answer is not yes or stdin is not a terminal
+func confirm(q string) bool {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false
 	}
-	return fID, nil
+	fmt.Fprintf(os.Stderr, "%v Only 'yes' will be accepted: ", q)
+	a, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(a) == "yes"
 }
@@ -0,0 +1,98 @@
+// syncthing cli tool - log viewer
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tenox7/stc/api"
+)
+
+var logLevels = []string{"debug", "verbose", "info", "warn", "error"}
+
+// logLevel returns index in logLevels of a syncthing log level, which are
+// numbered from 0 for debug in v1, and slog levels in v2
+func logLevel(v2 bool, l int) int {
+	if !v2 {
+		return min(max(l, 0), 3)
+	}
+	switch {
+	case l >= 8:
+		return 4
+	case l >= 4:
+		return 3
+	case l >= 0:
+		return 2
+	}
+	return 0
+}
+
+func showLog(args []string) error {
+	fs := flag.NewFlagSet("log", flag.ExitOnError)
+	lvl := fs.String("level", "", "minimum level: "+strings.Join(logLevels, ", "))
+	sinc := fs.Duration("since", 0, "only entries newer than this, eg. 10m")
+	grep := fs.String("grep", "", "only entries matching regular expression")
+	follow := fs.Bool("follow", false, "keep printing new entries")
+	txt := fs.Bool("txt", false, "print log.txt verbatim")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *txt {
+		return dumpLogTxt()
+	}
+
+	minLvl := 0
+	if *lvl != "" {
+		minLvl = slices.Index(logLevels, strings.ToLower(*lvl))
+		if minLvl < 0 {
+			return fmt.Errorf("unknown level %q, use %v", *lvl, strings.Join(logLevels, ", "))
+		}
+	}
+	var re *regexp.Regexp
+	if *grep != "" {
+		var err error
+		if re, err = regexp.Compile(*grep); err != nil {
+			return err
+		}
+	}
+	v, err := api.GetSysVersion()
+	if err != nil {
+		return err
+	}
+	v2 := !strings.HasPrefix(v.Version, "v1.")
+
+	last := time.Time{}
+	if *sinc > 0 {
+		last = time.Now().Add(-*sinc)
+	}
+	for {
+		l, err := api.GetSysLog(last)
+		if err != nil {
+			return err
+		}
+		from := last
+		for _, m := range l.Messages {
+			if !m.When.After(from) {
+				continue
+			}
+			last = m.When
+			ml := logLevel(v2, m.Level)
+			if ml < minLvl || (re != nil && !re.MatchString(m.Message)) {
+				continue
+			}
+			fmt.Printf("%v  %-7v  %v\n",
+				m.When.Local().Format("2006-01-02 15:04:05"),
+				strings.ToUpper(logLevels[ml]),
+				m.Message,
+			)
+		}
+		if !*follow {
+			return nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
@@ -0,0 +1,101 @@
+// syncthing cli tool - prometheus metrics
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type metricsConfig struct {
+	Listen string `yaml:"listen"`
+}
+
+// metricsHandler serves the dashboard snapshot in prometheus text format,
+// collected on every scrape
+func metricsHandler() http.Handler {
+	m := http.NewServeMux()
+	m.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		s, err := getSnapshot()
+		if err != nil {
+			slog.Error("metrics", "err", err)
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			fmt.Fprintf(w, "# HELP stc_up Syncthing API reachable.\n# TYPE stc_up gauge\nstc_up 0\n")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, s)
+	})
+	return m
+}
+
+func writeMetrics(w io.Writer, s snapshot) {
+	g := func(name, help string) {
+		fmt.Fprintf(w, "# HELP %[1]v %[2]v\n# TYPE %[1]v gauge\n", name, help)
+	}
+	g("stc_up", "Syncthing API reachable.")
+	fmt.Fprintf(w, "stc_up 1\n")
+	g("stc_uptime_seconds", "Syncthing uptime.")
+	fmt.Fprintf(w, "stc_uptime_seconds %v\n", s.Status.Uptime)
+	g("stc_info", "Syncthing version.")
+	fmt.Fprintf(w, "stc_info{host=%v,version=%v} 1\n", promStr(s.MyName), promStr(s.Version.Version))
+
+	fl := func(f SyncFolder) string {
+		return fmt.Sprintf("{folder=%v,id=%v}", promStr(f.Name), promStr(f.ID))
+	}
+	g("stc_folder_status", "Folder status, value is always 1.")
+	for _, f := range s.Folders {
+		fmt.Fprintf(w, "stc_folder_status{folder=%v,id=%v,status=%v} 1\n", promStr(f.Name), promStr(f.ID), promStr(f.Status))
+	}
+	g("stc_folder_sync_percent", "Folder completion.")
+	for _, f := range s.Folders {
+		fmt.Fprintf(w, "stc_folder_sync_percent%v %v\n", fl(f), f.Sync)
+	}
+	g("stc_folder_global_bytes", "Folder size in the cluster.")
+	for _, f := range s.Folders {
+		fmt.Fprintf(w, "stc_folder_global_bytes%v %v\n", fl(f), f.Global)
+	}
+	g("stc_folder_local_bytes", "Folder size on this device.")
+	for _, f := range s.Folders {
+		fmt.Fprintf(w, "stc_folder_local_bytes%v %v\n", fl(f), f.Local)
+	}
+	g("stc_folder_need_bytes", "Bytes this device is missing.")
+	for _, f := range s.Folders {
+		fmt.Fprintf(w, "stc_folder_need_bytes%v %v\n", fl(f), f.Needs)
+	}
+
+	dl := func(d SyncDevice) string {
+		return fmt.Sprintf("{device=%v,id=%v}", promStr(strings.TrimPrefix(d.Name, "*")), promStr(d.ID))
+	}
+	g("stc_device_connected", "Device is connected.")
+	for _, d := range s.Devices {
+		c := 0
+		if d.Status == "OK" {
+			c = 1
+		}
+		fmt.Fprintf(w, "stc_device_connected%v %v\n", dl(d), c)
+	}
+	g("stc_device_sync_percent", "Device completion.")
+	for _, d := range s.Devices {
+		fmt.Fprintf(w, "stc_device_sync_percent%v %v\n", dl(d), d.Sync)
+	}
+	g("stc_device_need_bytes", "Bytes the device is missing.")
+	for _, d := range s.Devices {
+		fmt.Fprintf(w, "stc_device_need_bytes%v %v\n", dl(d), d.Needs)
+	}
+	fmt.Fprintf(w, "# HELP stc_device_in_bytes_total Bytes received from device.\n# TYPE stc_device_in_bytes_total counter\n")
+	for _, d := range s.Devices {
+		fmt.Fprintf(w, "stc_device_in_bytes_total%v %v\n", dl(d), d.Download)
+	}
+	fmt.Fprintf(w, "# HELP stc_device_out_bytes_total Bytes sent to device.\n# TYPE stc_device_out_bytes_total counter\n")
+	for _, d := range s.Devices {
+		fmt.Fprintf(w, "stc_device_out_bytes_total%v %v\n", dl(d), d.Upload)
+	}
+}
+
+// promStr quotes a label value
+func promStr(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
@@ -0,0 +1,359 @@
+// syncthing cli tool - notifications on state changes
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tenox7/stc/api"
+)
+
+// notice is a single notification, sent as json to webhooks and commands
+type notice struct {
+	Event   string    `json:"event"`
+	Host    string    `json:"host"`
+	ID      string    `json:"id,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	Status  string    `json:"status,omitempty"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+var notifyEvents = []string{"folder_error", "device_offline", "sync_complete", "system_error"}
+
+// events which make notifier look at the state before its poll timeout
+const notifyWake = "StateChanged,FolderSummary,FolderCompletion,FolderErrors,FolderPaused,FolderResumed," +
+	"DeviceConnected,DeviceDisconnected,DevicePaused,DeviceResumed,ConfigSaved"
+
+type sink interface {
+	send(n notice) error
+}
+
+// cond is a condition worth a notice, fired once it held long enough
+type cond struct {
+	n     notice
+	since time.Time
+	fired bool
+}
+
+type notifier struct {
+	on       map[string]bool
+	grace    time.Duration
+	debounce time.Duration
+	every    time.Duration
+	sinks    []sink
+	conds    map[string]*cond
+	started  bool
+	uptime   int64
+	restart  bool
+}
+
+// notifyConfig holds notify flags, also used in agent config
+type notifyConfig struct {
+	On       []string      `yaml:"on"`
+	Webhook  string        `yaml:"webhook"`
+	Exec     string        `yaml:"exec"`
+	Grace    time.Duration `yaml:"grace"`
+	Debounce time.Duration `yaml:"debounce"`
+	SMTP     *smtpConfig   `yaml:"smtp"`
+}
+
+func notify(args []string) error {
+	fs := flag.NewFlagSet("notify", flag.ExitOnError)
+	nc := notifyConfig{}
+	on := fs.String("on", strings.Join(notifyEvents, ","), "comma separated events to notify on")
+	fs.StringVar(&nc.Webhook, "webhook", "", "URL to POST json notifications to")
+	fs.StringVar(&nc.Exec, "exec", "", "command to run for notifications, with json on stdin")
+	fs.DurationVar(&nc.Grace, "grace", 2*time.Minute, "how long a device must be offline")
+	fs.DurationVar(&nc.Debounce, "debounce", 10*time.Second, "how long other conditions must last")
+	test := fs.Bool("test", false, "send a test notification and exit")
+	sc := smtpConfig{}
+	fs.StringVar(&sc.Server, "smtp", "", "SMTP server host:port to mail digests to")
+	fs.StringVar(&sc.TLS, "smtp_tls", "starttls", "SMTP encryption: starttls, tls or none")
+	fs.StringVar(&sc.User, "smtp_user", "", "SMTP user")
+	fs.StringVar(&sc.Password, "smtp_password", "", "SMTP password, default $STC_SMTP_PASSWORD")
+	fs.StringVar(&sc.From, "smtp_from", "", "mail sender address")
+	smtpTo := fs.String("smtp_to", "", "comma separated mail recipients")
+	fs.DurationVar(&sc.Interval, "smtp_interval", 15*time.Minute, "minimum time between mails")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	nc.On = strings.Split(*on, ",")
+	if sc.Server != "" {
+		if *smtpTo != "" {
+			sc.To = strings.Split(*smtpTo, ",")
+		}
+		nc.SMTP = &sc
+	}
+
+	nt, err := newNotifier(nc)
+	if err != nil {
+		return err
+	}
+	defer nt.close()
+	if *test {
+		s, err := getSnapshot()
+		if err != nil {
+			return err
+		}
+		return nt.send(notice{Event: "test", Host: s.MyName, Message: "test notification", Time: time.Now()})
+	}
+	return nt.run(context.Background())
+}
+
+func newNotifier(nc notifyConfig) (*notifier, error) {
+	nt := &notifier{
+		on:       map[string]bool{},
+		grace:    nc.Grace,
+		debounce: nc.Debounce,
+		every:    5 * time.Second,
+		conds:    map[string]*cond{},
+	}
+	if nt.grace <= 0 {
+		nt.grace = 2 * time.Minute
+	}
+	if nt.debounce <= 0 {
+		nt.debounce = 10 * time.Second
+	}
+	if nc.Webhook != "" {
+		nt.sinks = append(nt.sinks, webhookSink(nc.Webhook))
+	}
+	if nc.Exec != "" {
+		nt.sinks = append(nt.sinks, execSink(nc.Exec))
+	}
+	if nc.SMTP != nil {
+		s, err := newSmtpSink(*nc.SMTP)
+		if err != nil {
+			return nil, err
+		}
+		nt.sinks = append(nt.sinks, s)
+	}
+	if len(nt.sinks) == 0 {
+		return nil, fmt.Errorf("no notification sink, use --webhook, --exec or --smtp")
+	}
+	if len(nc.On) == 0 {
+		nc.On = notifyEvents
+	}
+	for _, e := range nc.On {
+		e = strings.TrimSpace(e)
+		if !slices.Contains(notifyEvents, e) {
+			return nil, fmt.Errorf("unknown event %q, use %v", e, strings.Join(notifyEvents, ","))
+		}
+		nt.on[e] = true
+	}
+	return nt, nil
+}
+
+// close sends notices still waiting in digests
+func (nt *notifier) close() {
+	for _, s := range nt.sinks {
+		if f, ok := s.(interface{ close() error }); ok {
+			if err := f.close(); err != nil {
+				slog.Error("notify", "err", err)
+			}
+		}
+	}
+}
+
+// run checks the state whenever syncthing reports a change or a pending
+// condition is due, conditions present at start are not notified. Changes
+// are checked at most once per nt.every, a busy node sends lots of events.
+func (nt *notifier) run(ctx context.Context) error {
+	since := 0
+	for ctx.Err() == nil {
+		last := time.Now()
+		if err := nt.check(last); err != nil {
+			slog.Error("notify", "err", err)
+			sleepCtx(ctx, 10*time.Second)
+			continue
+		}
+		// event ids start over when syncthing restarts
+		if nt.restart {
+			since = 0
+		}
+		ev, err := api.WaitEvents(ctx, notifyWake, since, nt.wait(time.Now()))
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("notify", "err", err)
+				sleepCtx(ctx, 10*time.Second)
+			}
+			continue
+		}
+		if len(ev) > 0 {
+			since = ev[len(ev)-1].ID
+			sleepCtx(ctx, time.Until(last.Add(nt.every)))
+		}
+	}
+	return nil
+}
+
+func (nt *notifier) check(now time.Time) error {
+	s, err := getSnapshot()
+	if err != nil {
+		return err
+	}
+	se, err := api.GetSysErrors()
+	if err != nil {
+		return err
+	}
+	nt.update(now, s, se)
+	return nil
+}
+
+// update notifies conditions in s which lasted long enough
+func (nt *notifier) update(now time.Time, s snapshot, se api.SysErrors) {
+	nt.restart = s.Status.Uptime < nt.uptime
+	nt.uptime = s.Status.Uptime
+
+	cur := map[string]notice{}
+	add := func(ev, id, name, status, msg string) {
+		if nt.on[ev] {
+			cur[ev+"/"+id+"/"+status] = notice{Event: ev, Host: s.MyName, ID: id, Name: name, Status: status, Message: msg}
+		}
+	}
+	for _, f := range s.Folders {
+		n := f.Name
+		if n == "" {
+			n = f.ID
+		}
+		switch f.Status {
+		case "Errors", "OoSync", "LocAdds":
+			add("folder_error", f.ID, n, f.Status, fmt.Sprintf("folder %v is %v", n, f.Status))
+		case "Paused":
+		default:
+			if f.Sync >= 100 {
+				add("sync_complete", f.ID, n, "", fmt.Sprintf("folder %v is in sync", n))
+			}
+		}
+	}
+	for _, d := range s.Devices {
+		if d.Status == "Offline" {
+			add("device_offline", d.ID, d.Name, d.Status, fmt.Sprintf("device %v is offline", d.Name))
+		}
+	}
+	for _, e := range se.Errors {
+		add("system_error", e.When, "", "", e.Message)
+	}
+
+	for k, n := range cur {
+		c, ok := nt.conds[k]
+		if !ok {
+			n.Time = now
+			c = &cond{n: n, since: now, fired: !nt.started}
+			nt.conds[k] = c
+		}
+		if c.fired || now.Sub(c.since) < nt.hold(n.Event) {
+			continue
+		}
+		c.fired = true
+		if err := nt.send(c.n); err != nil {
+			slog.Error("notify", "err", err)
+		}
+	}
+	for k := range nt.conds {
+		if _, ok := cur[k]; !ok {
+			delete(nt.conds, k)
+		}
+	}
+	nt.started = true
+}
+
+// hold is how long a condition must last to be notified, anything shorter
+// is considered flapping
+func (nt *notifier) hold(ev string) time.Duration {
+	switch ev {
+	case "device_offline":
+		return nt.grace
+	case "system_error":
+		return 0
+	}
+	return nt.debounce
+}
+
+// wait returns time until the next pending condition is due
+func (nt *notifier) wait(now time.Time) time.Duration {
+	w := time.Minute
+	for _, c := range nt.conds {
+		if !c.fired {
+			w = min(w, c.since.Add(nt.hold(c.n.Event)).Sub(now))
+		}
+	}
+	return max(w, 0) + time.Second
+}
+
+func (nt *notifier) send(n notice) error {
+	log.Printf("%v: %v", n.Event, n.Message)
+	errs := []string{}
+	for _, s := range nt.sinks {
+		if err := s.send(n); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("notification failed: %v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+type webhookSink string
+
+func (w webhookSink) send(n notice) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	r, err := webhookClient.Post(string(w), "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	if r.StatusCode >= 300 {
+		return fmt.Errorf("webhook %v: %v", string(w), r.Status)
+	}
+	return nil
+}
+
+// execSink runs a command with the notice as json on stdin and its fields
+// in STC_ environment variables
+type execSink string
+
+func (e execSink) send(n notice) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	cmd := shellCmd(string(e))
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(),
+		"STC_EVENT="+n.Event,
+		"STC_HOST="+n.Host,
+		"STC_ID="+n.ID,
+		"STC_NAME="+n.Name,
+		"STC_STATUS="+n.Status,
+		"STC_MESSAGE="+n.Message,
+	)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("exec %q: %w", string(e), err)
+	}
+	return nil
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
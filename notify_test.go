@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tenox7/stc/api"
+)
+
+// receiver is a webhook endpoint collecting notices
+type receiver struct {
+	sync.Mutex
+	got []notice
+	ct  string
+}
+
+func newReceiver(t *testing.T) (*receiver, *httptest.Server) {
+	rc := &receiver{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := notice{}
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("bad webhook body: %v", err)
+		}
+		rc.Lock()
+		rc.got = append(rc.got, n)
+		rc.ct = r.Header.Get("Content-Type")
+		rc.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	return rc, srv
+}
+
+func (rc *receiver) events() []string {
+	rc.Lock()
+	defer rc.Unlock()
+	e := []string{}
+	for _, n := range rc.got {
+		e = append(e, n.Event+" "+n.Name)
+	}
+	return e
+}
+
+func testSnap(folder, device string) snapshot {
+	return snapshot{
+		MyName:  "nas",
+		Folders: []SyncFolder{{ID: "f1", Name: "pics", Status: folder, Sync: 50}},
+		Devices: []SyncDevice{{ID: "D1", Name: "laptop", Status: device}},
+	}
+}
+
+func TestWebhookPayload(t *testing.T) {
+	rc, srv := newReceiver(t)
+	tm := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	n := notice{Event: "device_offline", Host: "nas", ID: "D1", Name: "laptop", Status: "Offline", Message: "device laptop is offline", Time: tm}
+	if err := webhookSink(srv.URL).send(n); err != nil {
+		t.Fatal(err)
+	}
+	if rc.ct != "application/json" {
+		t.Errorf("content type %q", rc.ct)
+	}
+	if len(rc.got) != 1 || rc.got[0] != n {
+		t.Errorf("got %+v, want %+v", rc.got, n)
+	}
+
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+	if err := webhookSink(bad.URL).send(n); err == nil {
+		t.Error("no error for failing webhook")
+	}
+}
+
+func TestNotifierGrace(t *testing.T) {
+	rc, srv := newReceiver(t)
+	nt, err := newNotifier(notifyConfig{Webhook: srv.URL, On: []string{"device_offline"}, Grace: 2 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Now()
+	se := api.SysErrors{}
+	steps := []struct {
+		at     time.Duration
+		device string
+		want   int
+	}{
+		{0, "OK", 0},
+		{time.Second, "Offline", 0},
+		{time.Minute, "Offline", 0},
+		{90 * time.Second, "OK", 0}, // back before grace, flapping
+		{100 * time.Second, "Offline", 0},
+		{3 * time.Minute, "Offline", 0},
+		{220 * time.Second, "Offline", 1},
+		{5 * time.Minute, "Offline", 1}, // notified once only
+		{6 * time.Minute, "OK", 1},
+	}
+	for _, s := range steps {
+		nt.update(t0.Add(s.at), testSnap("Idle", s.device), se)
+		if e := rc.events(); len(e) != s.want {
+			t.Fatalf("at %v: got %v, want %v notices", s.at, e, s.want)
+		}
+	}
+	if e := rc.events(); e[0] != "device_offline laptop" {
+		t.Errorf("got %v", e)
+	}
+}
+
+func TestNotifierDebounce(t *testing.T) {
+	rc, srv := newReceiver(t)
+	nt, err := newNotifier(notifyConfig{Webhook: srv.URL, On: []string{"folder_error"}, Debounce: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Now()
+	se := api.SysErrors{}
+	steps := []struct {
+		at     time.Duration
+		folder string
+		want   int
+	}{
+		{0, "Errors", 0}, // present at start, not notified
+		{time.Minute, "Errors", 0},
+		{2 * time.Minute, "Idle", 0},
+		{3 * time.Minute, "Errors", 0},
+		{3*time.Minute + 5*time.Second, "Idle", 0}, // shorter than debounce
+		{4 * time.Minute, "OoSync", 0},
+		{4*time.Minute + 10*time.Second, "OoSync", 1},
+		{4*time.Minute + 20*time.Second, "Errors", 1}, // new status starts over
+		{4*time.Minute + 30*time.Second, "Errors", 2},
+	}
+	for _, s := range steps {
+		nt.update(t0.Add(s.at), testSnap(s.folder, "OK"), se)
+		if e := rc.events(); len(e) != s.want {
+			t.Fatalf("at %v: got %v, want %v notices", s.at, e, s.want)
+		}
+	}
+	if w := nt.wait(t0.Add(5 * time.Minute)); w != time.Minute+time.Second {
+		t.Errorf("wait with nothing pending %v", w)
+	}
+	nt.update(t0.Add(5*time.Minute), testSnap("LocAdds", "OK"), se)
+	if w := nt.wait(t0.Add(5*time.Minute + 4*time.Second)); w != 7*time.Second {
+		t.Errorf("wait for pending condition %v", w)
+	}
+}
@@ -0,0 +1,217 @@
+// syncthing cli tool - config.xml inspection without running daemon
+package main
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tenox7/stc/api"
+)
+
+// offlineConfig reads config.xml directly from syncthing home directory
+func offlineConfig(homeDir string, args []string) error {
+	fs := flag.NewFlagSet("offline_config", flag.ExitOnError)
+	show := fs.Bool("show_secrets", false, "print API Key and passwords in json")
+	pos, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) > 1 {
+		return fmt.Errorf("usage: offline_config [folders|devices|json|validate] [--show_secrets]")
+	}
+	cmd := ""
+	if len(pos) == 1 {
+		cmd = pos[0]
+	}
+
+	b, path, err := homeFile(homeDir, "config.xml")
+	if err != nil {
+		return err
+	}
+	cfg, err := api.ParseConfigXML(b)
+	if err != nil {
+		var se *xml.SyntaxError
+		if errors.As(err, &se) {
+			return fmt.Errorf("%v:%v: %v", path, se.Line, se.Msg)
+		}
+		return fmt.Errorf("%v: %w", path, err)
+	}
+
+	// own device ID is derived from cert.pem, if present
+	myID := ""
+	if c, _, err := homeFile(homeDir, "cert.pem"); err == nil {
+		if p, _ := pem.Decode(c); p != nil {
+			myID = api.DeviceIDFromCert(p.Bytes)
+		}
+	}
+
+	switch cmd {
+	case "":
+		fmt.Printf("Config %v version %v\n\n", path, cfg.Version)
+		offlineFolders(cfg)
+		fmt.Println()
+		offlineDevices(cfg, myID)
+		return nil
+	case "folders":
+		offlineFolders(cfg)
+		return nil
+	case "devices":
+		offlineDevices(cfg, myID)
+		return nil
+	case "json":
+		j, err := json.MarshalIndent(cfg, "", "  ")
+		if err != nil {
+			return err
+		}
+		if !*show {
+			j, err = redactConfig(j)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Println(string(j))
+		return nil
+	case "validate":
+		return offlineValidate(cfg, myID, path)
+	}
+	return fmt.Errorf("unknown offline_config command %q, use folders, devices, json or validate", cmd)
+}
+
+func offlineFolders(cfg api.Config) {
+	t := tabwriter.NewWriter(os.Stdout, 9, 0, 2, ' ', tabwriter.TabIndent)
+	fmt.Fprintf(t, "Folder\tID\tType\tPaused\tDevices\tPath\n")
+	for _, f := range cfg.Folders {
+		fmt.Fprintf(t, "%v\t%v\t%v\t%v\t%v\t%v\n",
+			f.Label,
+			f.ID,
+			f.Type,
+			f.Paused,
+			len(f.Devices),
+			f.Path,
+		)
+	}
+	t.Flush()
+}
+
+func offlineDevices(cfg api.Config, myID string) {
+	t := tabwriter.NewWriter(os.Stdout, 9, 0, 2, ' ', tabwriter.TabIndent)
+	fmt.Fprintf(t, "Device\tID\tPaused\tFolders\tAddresses\n")
+	for _, d := range cfg.Devices {
+		if d.DeviceID == myID {
+			d.Name = "*" + d.Name
+		}
+		nf := 0
+		for _, f := range cfg.Folders {
+			for _, fd := range f.Devices {
+				if fd.DeviceID == d.DeviceID {
+					nf++
+				}
+			}
+		}
+		fmt.Fprintf(t, "%v\t%v\t%v\t%v\t%v\n",
+			d.Name,
+			d.DeviceID[:min(7, len(d.DeviceID))],
+			d.Paused,
+			nf,
+			strings.Join(d.Addresses, ","),
+		)
+	}
+	t.Flush()
+}
+
+// offlineValidate prints problems found in the config, returning error if
+// there are any that would prevent syncthing from working
+func offlineValidate(cfg api.Config, myID, path string) error {
+	errs, warns := 0, 0
+	e := func(f string, a ...interface{}) {
+		fmt.Printf("ERROR: "+f+"\n", a...)
+		errs++
+	}
+	w := func(f string, a ...interface{}) {
+		fmt.Printf("WARNING: "+f+"\n", a...)
+		warns++
+	}
+
+	if cfg.Version == 0 {
+		e("configuration version attribute is missing")
+	}
+
+	devs := map[string]bool{}
+	for _, d := range cfg.Devices {
+		if err := api.CheckDeviceID(d.DeviceID); err != nil {
+			e("device %q: %v", d.Name, err)
+		}
+		if devs[d.DeviceID] {
+			e("device %q: duplicate device ID %v", d.Name, d.DeviceID)
+		}
+		devs[d.DeviceID] = true
+		if len(d.Addresses) == 0 {
+			w("device %q has no addresses, use 'dynamic' for discovery", d.Name)
+		}
+	}
+	if myID != "" && !devs[myID] {
+		e("this device %v from cert.pem is not in the device list", myID)
+	}
+
+	fids := map[string]bool{}
+	for _, f := range cfg.Folders {
+		n := f.Label
+		if n == "" {
+			n = f.ID
+		}
+		switch {
+		case f.ID == "":
+			e("folder %q has empty ID", n)
+		case fids[f.ID]:
+			e("folder %q: duplicate folder ID %v", n, f.ID)
+		}
+		fids[f.ID] = true
+		if f.Path == "" {
+			e("folder %q has empty path", n)
+		} else if sshc == nil && !strings.HasPrefix(f.Path, "~") {
+			if _, err := os.Stat(f.Path); err != nil {
+				w("folder %q path: %v", n, err)
+			}
+		}
+		switch f.Type {
+		case "sendreceive", "sendonly", "receiveonly", "receiveencrypted":
+		default:
+			e("folder %q has unknown type %q", n, f.Type)
+		}
+		for _, fd := range f.Devices {
+			if !devs[fd.DeviceID] {
+				e("folder %q is shared with unknown device %v", n, fd.DeviceID)
+			}
+		}
+		if len(f.Devices) < 2 {
+			w("folder %q is not shared with any remote device", n)
+		}
+	}
+
+	if cfg.GUI.Enabled && cfg.GUI.Address != "" &&
+		!strings.HasPrefix(cfg.GUI.Address, "/") && !strings.HasPrefix(cfg.GUI.Address, "unix://") {
+		if _, _, err := net.SplitHostPort(cfg.GUI.Address); err != nil {
+			e("gui address %q: %v", cfg.GUI.Address, err)
+		}
+	}
+	if cfg.GUI.Enabled && cfg.GUI.APIKey == "" && cfg.GUI.User == "" {
+		w("gui has neither api key nor user set")
+	}
+	if len(cfg.Options.ListenAddresses) == 0 {
+		w("no listen addresses, use 'default'")
+	}
+
+	fmt.Printf("%v: %v errors, %v warnings\n", path, errs, warns)
+	if errs > 0 {
+		return fmt.Errorf("config validation failed")
+	}
+	return nil
+}
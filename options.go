@@ -0,0 +1,177 @@
+// syncthing cli tool - daemon wide options
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tenox7/stc/api"
+)
+
+func getOptions() (map[string]interface{}, error) {
+	b, err := api.GetOptionsRaw()
+	if err != nil {
+		return nil, err
+	}
+	o := map[string]interface{}{}
+	if err := json.Unmarshal(b, &o); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func dumpOptions() error {
+	o, err := getOptions()
+	if err != nil {
+		return err
+	}
+	t := tabwriter.NewWriter(os.Stdout, 9, 0, 2, ' ', tabwriter.TabIndent)
+	fmt.Fprintf(t, "Option\tValue\n")
+	for _, k := range slices.Sorted(maps.Keys(o)) {
+		fmt.Fprintf(t, "%v\t%v\n", k, optStr(o[k]))
+	}
+	t.Flush()
+	return nil
+}
+
+func optStr(v interface{}) string {
+	switch vv := v.(type) {
+	case []interface{}:
+		s := []string{}
+		for _, e := range vv {
+			s = append(s, fmt.Sprint(e))
+		}
+		return strings.Join(s, ",")
+	case string, bool, float64:
+		return fmt.Sprint(vv)
+	}
+	return jsonStr(v)
+}
+
+// optionsSet patches options given as key=value arguments, values are
+// converted to the type of the current option value
+func optionsSet(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: options_set key=value ...")
+	}
+	o, err := getOptions()
+	if err != nil {
+		return err
+	}
+	p := map[string]interface{}{}
+	for _, a := range args {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok {
+			return fmt.Errorf("option %q must be in key=value form", a)
+		}
+		cur, ok := o[k]
+		if !ok {
+			return fmt.Errorf("unknown option %q", k)
+		}
+		p[k], err = optValue(cur, v)
+		if err != nil {
+			return fmt.Errorf("option %v: %w", k, err)
+		}
+	}
+	return api.PatchOptions(p)
+}
+
+func optValue(cur interface{}, v string) (interface{}, error) {
+	switch cv := cur.(type) {
+	case bool:
+		return strconv.ParseBool(v)
+	case float64:
+		if cv == float64(int64(cv)) {
+			return strconv.ParseInt(v, 10, 64)
+		}
+		return strconv.ParseFloat(v, 64)
+	case string:
+		return v, nil
+	case []interface{}:
+		l := []string{}
+		for _, e := range strings.Split(v, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				l = append(l, e)
+			}
+		}
+		return l, nil
+	}
+	// objects such as minHomeDiskFree are given as json
+	var j interface{}
+	if err := json.Unmarshal([]byte(v), &j); err != nil {
+		return nil, fmt.Errorf("value must be json: %w", err)
+	}
+	if _, ok := j.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("value must be a json object")
+	}
+	return j, nil
+}
+
+// ratelimit sets global send and receive limits, showing them if none given
+func ratelimit(args []string) error {
+	fs := flag.NewFlagSet("ratelimit", flag.ExitOnError)
+	send := fs.String("send", "", "max send rate, eg. 500K, 5M, 0 for unlimited")
+	recv := fs.String("recv", "", "max receive rate, eg. 500K, 5M, 0 for unlimited")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	p := map[string]interface{}{}
+	for n, v := range map[string]string{"maxSendKbps": *send, "maxRecvKbps": *recv} {
+		if v == "" {
+			continue
+		}
+		k, err := parseRate(v)
+		if err != nil {
+			return err
+		}
+		p[n] = k
+	}
+	if len(p) > 0 {
+		return api.PatchOptions(p)
+	}
+
+	o, err := getOptions()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Send: %v\nRecv: %v\n", rateStr(o["maxSendKbps"]), rateStr(o["maxRecvKbps"]))
+	return nil
+}
+
+// parseRate converts rate with optional K, M or G suffix to KiB/s used by
+// syncthing, plain numbers are KiB/s
+func parseRate(r string) (int64, error) {
+	m, s := int64(1), r
+	switch strings.ToUpper(r[len(r)-1:]) {
+	case "K":
+		s = r[:len(r)-1]
+	case "M":
+		m, s = 1024, r[:len(r)-1]
+	case "G":
+		m, s = 1024*1024, r[:len(r)-1]
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid rate %q", r)
+	}
+	return int64(f * float64(m)), nil
+}
+
+func rateStr(v interface{}) string {
+	k, _ := v.(float64)
+	switch {
+	case k == 0:
+		return "unlimited"
+	case k >= 1024:
+		return strconv.FormatFloat(k/1024, 'f', -1, 64) + " MiB/s"
+	}
+	return strconv.FormatFloat(k, 'f', -1, 64) + " KiB/s"
+}
@@ -0,0 +1,101 @@
+// syncthing cli tool - pull queue priority
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/tenox7/stc/api"
+)
+
+// prioritize moves files to the front of the pull queue. Globs are matched
+// against the files the folder needs, the files are pulled in the given order.
+func prioritize(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: prioritize <folder> <path|glob>...")
+	}
+	f, err := resolveFolder(args[0])
+	if err != nil {
+		return err
+	}
+	dn, err := api.GetNeed(f.ID)
+	if err != nil {
+		return err
+	}
+	need := []string{}
+	for _, n := range slices.Concat(dn.Progress, dn.Queued, dn.Rest) {
+		need = append(need, n.Name)
+	}
+
+	files := []string{}
+	for _, a := range args[1:] {
+		if !strings.ContainsAny(a, "*?[") {
+			files = append(files, a)
+			continue
+		}
+		if _, err := path.Match(a, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %w", a, err)
+		}
+		m := 0
+		for _, n := range need {
+			if ok, _ := path.Match(a, n); ok && !slices.Contains(files, n) {
+				files = append(files, n)
+				m++
+			}
+		}
+		if m == 0 {
+			return fmt.Errorf("no needed file in %v matches %q", f, a)
+		}
+	}
+
+	// each file goes to the front, so the first one is sent last
+	for i := len(files) - 1; i >= 0; i-- {
+		if !slices.Contains(need, files[i]) {
+			fmt.Printf("Warning: %v is not needed by %v\n", files[i], f)
+		}
+		dn, err = api.Prio(f.ID, files[i])
+		if err != nil {
+			return err
+		}
+	}
+	dumpNeed(dn)
+	return nil
+}
+
+// dumpNeed prints the pull queue, the first 20 files unless -limit is set
+func dumpNeed(dn api.DbNeed) {
+	if len(dn.Progress)+len(dn.Queued)+len(dn.Rest) == 0 {
+		fmt.Println("Nothing to pull")
+		return
+	}
+	rows := 20
+	if *limit > 0 {
+		rows = *limit
+	}
+	t := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(t, "File\tState\tSize\n")
+	n, more, moreB := 0, 0, int64(0)
+	for _, l := range []struct {
+		state string
+		files []api.NeedFile
+	}{{"pulling", dn.Progress}, {"queued", dn.Queued}, {"waiting", dn.Rest}} {
+		for _, f := range l.files {
+			if n >= rows {
+				more++
+				moreB += f.Size
+				continue
+			}
+			fmt.Fprintf(t, "%v\t%v\t%v\n", f.Name, l.state, humanize.Bytes(uint64(f.Size)))
+			n++
+		}
+	}
+	t.Flush()
+	if more > 0 {
+		fmt.Printf("... %v more files, %v\n", more, humanize.Bytes(uint64(moreB)))
+	}
+}
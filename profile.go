@@ -0,0 +1,297 @@
+// syncthing cli tool - named connection profiles
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/tenox7/stc/api"
+)
+
+// profile is a named set of connection settings from stc config file,
+// each field applies as the default of the flag with the same name
+type profile struct {
+	Target        string `toml:"target,omitempty"`
+	ApiKey        string `toml:"apikey,omitempty"`
+	ApiKeyFile    string `toml:"apikey_file,omitempty"`
+	ApiKeyCommand string `toml:"apikey_command,omitempty"`
+	HomeDir       string `toml:"homedir,omitempty"`
+	Ssh           string `toml:"ssh,omitempty"`
+	User          string `toml:"user,omitempty"`
+	Password      string `toml:"password,omitempty"`
+	CaCert        string `toml:"cacert,omitempty"`
+	Pin           string `toml:"pin,omitempty"`
+	ClientCert    string `toml:"client_cert,omitempty"`
+	ClientKey     string `toml:"client_key,omitempty"`
+	IgnoreCert    bool   `toml:"ignore_cert_errors,omitempty"`
+	Output        string `toml:"output,omitempty"`
+	Timeout       string `toml:"timeout,omitempty"`
+}
+
+// flags selecting how to authenticate
+var credFlags = []string{"apikey", "apikey_file", "apikey_command", "user", "password"}
+
+func (p profile) flags() map[string]string {
+	m := map[string]string{
+		"target":         p.Target,
+		"apikey":         p.ApiKey,
+		"apikey_file":    p.ApiKeyFile,
+		"apikey_command": p.ApiKeyCommand,
+		"homedir":        p.HomeDir,
+		"ssh":            p.Ssh,
+		"user":           p.User,
+		"password":       p.Password,
+		"cacert":         p.CaCert,
+		"pin":            p.Pin,
+		"client_cert":    p.ClientCert,
+		"client_key":     p.ClientKey,
+		"output":         p.Output,
+		"timeout":        p.Timeout,
+	}
+	if p.IgnoreCert {
+		m["ignore_cert_errors"] = "true"
+	}
+	return m
+}
+
+func (p *profile) set(k, v string) error {
+	switch k {
+	case "target":
+		p.Target = v
+	case "apikey":
+		p.ApiKey = v
+	case "apikey_file":
+		p.ApiKeyFile = v
+	case "apikey_command":
+		p.ApiKeyCommand = v
+	case "homedir":
+		p.HomeDir = v
+	case "ssh":
+		p.Ssh = v
+	case "user":
+		p.User = v
+	case "password":
+		p.Password = v
+	case "cacert":
+		p.CaCert = v
+	case "pin":
+		p.Pin = v
+	case "client_cert":
+		p.ClientCert = v
+	case "client_key":
+		p.ClientKey = v
+	case "ignore_cert_errors":
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("ignore_cert_errors: %w", err)
+		}
+		p.IgnoreCert = b
+	case "output":
+		p.Output = v
+	case "timeout":
+		if _, err := time.ParseDuration(v); err != nil {
+			return fmt.Errorf("timeout: %w", err)
+		}
+		p.Timeout = v
+	default:
+		return fmt.Errorf("unknown setting %q", k)
+	}
+	return nil
+}
+
+func profileFile() (string, error) {
+	d, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d, "stc", "config.toml"), nil
+}
+
+func loadProfiles() (map[string]profile, error) {
+	f, err := profileFile()
+	if err != nil {
+		return nil, err
+	}
+	pr := map[string]profile{}
+	md, err := toml.DecodeFile(f, &pr)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return pr, nil
+		}
+		return nil, err
+	}
+	if u := md.Undecoded(); len(u) > 0 {
+		return nil, fmt.Errorf("%v: unknown setting %v", f, u[0])
+	}
+	return pr, nil
+}
+
+func saveProfiles(pr map[string]profile) error {
+	f, err := profileFile()
+	if err != nil {
+		return err
+	}
+	b := &bytes.Buffer{}
+	if err := toml.NewEncoder(b).Encode(pr); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f), 0700); err != nil {
+		return err
+	}
+	// profiles may contain api keys and passwords
+	if err := os.WriteFile(f, b.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Chmod(f, 0600)
+}
+
+// applyProfile sets flags not given on the command line from the named
+// profile. An empty name uses STC_PROFILE environment variable.
+func applyProfile(name string) error {
+	if name == "" {
+		name = os.Getenv("STC_PROFILE")
+	}
+	if name == "" {
+		return nil
+	}
+	pr, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	p, ok := pr[name]
+	if !ok {
+		return fmt.Errorf("profile %q not found", name)
+	}
+
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	// credentials are one group, any given on the command line replaces all
+	// of the profile, else its apikey would win over eg. --user. A password
+	// alone may complete the user of the profile.
+	cred := false
+	for _, n := range credFlags {
+		cred = cred || set[n] && n != "password"
+	}
+
+	for n, v := range p.flags() {
+		if v == "" || set[n] || cred && slices.Contains(credFlags, n) {
+			continue
+		}
+		if err := flag.Set(n, v); err != nil {
+			return fmt.Errorf("profile %q %v: %w", name, n, err)
+		}
+	}
+	return nil
+}
+
+func profileCmd(cmd string, args []string) error {
+	switch cmd {
+	case "list", "":
+		return profileList()
+	case "add":
+		return profileAdd(args)
+	case "remove":
+		return profileRemove(args)
+	}
+	return fmt.Errorf("unknown profile command %q, use list, add, remove or test", cmd)
+}
+
+func profileList() error {
+	pr, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	n := []string{}
+	for k := range pr {
+		n = append(n, k)
+	}
+	sort.Strings(n)
+
+	t := tabwriter.NewWriter(os.Stdout, 9, 0, 2, ' ', tabwriter.TabIndent)
+	fmt.Fprintf(t, "Profile\tTarget\tAuth\n")
+	for _, k := range n {
+		p := pr[k]
+		tg := p.Target
+		if p.Ssh != "" {
+			tg = "ssh://" + p.Ssh + tg
+		}
+		au := "config.xml"
+		switch {
+		case p.ApiKeyCommand != "":
+			au = "apikey_command"
+		case p.ApiKeyFile != "":
+			au = "apikey_file"
+		case p.ApiKey != "":
+			au = "apikey"
+		case p.User != "":
+			au = "user " + p.User
+		}
+		fmt.Fprintf(t, "%v\t%v\t%v\n", k, tg, au)
+	}
+	t.Flush()
+	return nil
+}
+
+// profileAdd creates or replaces a profile from name key=value ... arguments
+func profileAdd(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: profile add <name> key=value ...")
+	}
+	p := profile{}
+	for _, a := range args[1:] {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok {
+			return fmt.Errorf("setting %q must be in key=value form", a)
+		}
+		if err := p.set(k, v); err != nil {
+			return err
+		}
+	}
+
+	pr, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	pr[args[0]] = p
+	return saveProfiles(pr)
+}
+
+func profileRemove(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: profile remove <name>")
+	}
+	pr, err := loadProfiles()
+	if err != nil {
+		return err
+	}
+	if _, ok := pr[args[0]]; !ok {
+		return fmt.Errorf("profile %q not found", args[0])
+	}
+	delete(pr, args[0])
+	return saveProfiles(pr)
+}
+
+// profileTest checks the connection established with the selected profile
+func profileTest() error {
+	st, err := api.GetSysStatus()
+	if err != nil {
+		return err
+	}
+	sv, err := api.GetSysVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("OK %v %v\n", sv.Version, st.MyID)
+	return nil
+}
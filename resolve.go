@@ -0,0 +1,139 @@
+// syncthing cli tool - folder and device lookup
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/tenox7/stc/api"
+)
+
+// cfgObj is a folder or device as found by the resolver
+type cfgObj struct {
+	ID     string
+	Name   string
+	Paused bool
+}
+
+func (o cfgObj) String() string {
+	if o.Name == "" {
+		return o.ID
+	}
+	return o.Name + " (" + o.ID + ")"
+}
+
+func cfgFolders(cfg api.StConfig) []cfgObj {
+	l := []cfgObj{}
+	for _, f := range cfg.Folders {
+		l = append(l, cfgObj{ID: f.ID, Name: f.Label, Paused: f.Paused})
+	}
+	return l
+}
+
+func cfgDevices(cfg api.StConfig) []cfgObj {
+	l := []cfgObj{}
+	for _, d := range cfg.Devices {
+		l = append(l, cfgObj{ID: d.DeviceID, Name: d.Name, Paused: d.Paused})
+	}
+	return l
+}
+
+// findObjs matches s against IDs and names. Exact ID or name wins, then a
+// glob, then a prefix which must be unique. Device IDs are compared without
+// dashes and case, so short IDs from the dashboard work too.
+func findObjs(kind, s string, objs []cfgObj) ([]cfgObj, error) {
+	if s == "" {
+		return nil, fmt.Errorf("%v must be specified", kind)
+	}
+	norm := func(id string) string {
+		if kind == "device" {
+			return strings.ToUpper(strings.ReplaceAll(id, "-", ""))
+		}
+		return id
+	}
+	match := func(f func(o cfgObj) bool) []cfgObj {
+		m := []cfgObj{}
+		for _, o := range objs {
+			if f(o) {
+				m = append(m, o)
+			}
+		}
+		return m
+	}
+
+	if m := match(func(o cfgObj) bool { return norm(o.ID) == norm(s) }); len(m) > 0 {
+		return m, nil
+	}
+	if m := match(func(o cfgObj) bool { return o.Name == s }); len(m) > 0 {
+		return m, nil
+	}
+	if strings.ContainsAny(s, "*?[") {
+		if _, err := path.Match(s, ""); err != nil {
+			return nil, fmt.Errorf("bad %v pattern %q: %w", kind, s, err)
+		}
+		m := match(func(o cfgObj) bool {
+			a, _ := path.Match(s, o.ID)
+			b, _ := path.Match(s, o.Name)
+			return a || b
+		})
+		if len(m) == 0 {
+			return nil, fmt.Errorf("no %v matches %q", kind, s)
+		}
+		return m, nil
+	}
+	m := match(func(o cfgObj) bool {
+		return strings.HasPrefix(norm(o.ID), norm(s)) || strings.HasPrefix(o.Name, s)
+	})
+	switch len(m) {
+	case 0:
+		return nil, fmt.Errorf("%v %q not found", kind, s)
+	case 1:
+		return m, nil
+	}
+	return nil, ambiguous(kind, s, m)
+}
+
+func ambiguous(kind, s string, m []cfgObj) error {
+	l := []string{}
+	for _, o := range m {
+		l = append(l, o.String())
+	}
+	return fmt.Errorf("%v %q is ambiguous, matches %v", kind, s, strings.Join(l, ", "))
+}
+
+// findObj is findObjs for commands taking exactly one object
+func findObj(kind, s string, objs []cfgObj) (cfgObj, error) {
+	m, err := findObjs(kind, s, objs)
+	if err != nil {
+		return cfgObj{}, err
+	}
+	if len(m) > 1 {
+		return cfgObj{}, ambiguous(kind, s, m)
+	}
+	return m[0], nil
+}
+
+func resolveFolders(s string) ([]cfgObj, error) {
+	cfg, err := api.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return findObjs("folder", s, cfgFolders(cfg))
+}
+
+func resolveFolder(s string) (cfgObj, error) {
+	cfg, err := api.GetConfig()
+	if err != nil {
+		return cfgObj{}, err
+	}
+	return findObj("folder", s, cfgFolders(cfg))
+}
+
+func resolveDevice(s string) (cfgObj, error) {
+	cfg, err := api.GetConfig()
+	if err != nil {
+		return cfgObj{}, err
+	}
+	return findObj("device", s, cfgDevices(cfg))
+}
@@ -0,0 +1,107 @@
+// syncthing cli tool - restart and shutdown
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/tenox7/stc/api"
+)
+
+// folder states syncthing goes through after start, before it is usable
+var startStates = []string{"", "unknown", "starting", "scanning", "scan-waiting"}
+
+func restart(args []string) error {
+	fs := flag.NewFlagSet("restart", flag.ExitOnError)
+	wait := fs.Bool("wait", false, "wait until syncthing is back and folders are scanned")
+	tmo := fs.Duration("wait_timeout", 5*time.Minute, "how long to wait")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if !*wait {
+		return api.Restart()
+	}
+
+	st, err := api.GetSysStatus()
+	if err != nil {
+		return err
+	}
+	t := time.Now()
+	if err := api.Restart(); err != nil {
+		return err
+	}
+	if _, err := waitRestart(st, *tmo); err != nil {
+		return err
+	}
+	fmt.Printf("Syncthing restarted in %v\n", time.Since(t).Round(time.Second))
+	if err := waitFolders(t.Add(*tmo)); err != nil {
+		return err
+	}
+	fmt.Printf("Folders ready in %v\n", time.Since(t).Round(time.Second))
+	return nil
+}
+
+// waitFolders waits until no unpaused folder is starting or scanning
+func waitFolders(end time.Time) error {
+	for {
+		cfg, err := api.GetConfig()
+		if err != nil {
+			return err
+		}
+		busy := []string{}
+		for _, f := range cfg.Folders {
+			if f.Paused {
+				continue
+			}
+			fs, err := api.GetFolderStatus(f.ID)
+			if err != nil {
+				return err
+			}
+			if slices.Contains(startStates, fs.State) {
+				busy = append(busy, f.ID)
+			}
+		}
+		if len(busy) == 0 {
+			return nil
+		}
+		if time.Now().After(end) {
+			return fmt.Errorf("folders still starting: %v", busy)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func shutdown(args []string) error {
+	fs := flag.NewFlagSet("shutdown", flag.ExitOnError)
+	wait := fs.Bool("wait", false, "wait until syncthing stops answering")
+	tmo := fs.Duration("wait_timeout", time.Minute, "how long to wait")
+	yes, dry := guardFlags(fs)
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	n, err := myName()
+	if err != nil {
+		return err
+	}
+	ok, err := guard(fmt.Sprintf("shut down syncthing on %v, it will not restart by itself", n), *yes, *dry)
+	if !ok {
+		return err
+	}
+	t := time.Now()
+	if err := api.Shutdown(); err != nil {
+		return err
+	}
+	if !*wait {
+		return nil
+	}
+	for time.Since(t) < *tmo {
+		if api.Ping() != nil {
+			fmt.Printf("Syncthing stopped in %v\n", time.Since(t).Round(time.Second))
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return fmt.Errorf("syncthing still answering after %v", *tmo)
+}
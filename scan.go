@@ -0,0 +1,128 @@
+// syncthing cli tool - folder scans
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/tenox7/stc/api"
+)
+
+func rescan(args []string) error {
+	fs := flag.NewFlagSet("rescan", flag.ExitOnError)
+	subs := []string{}
+	fs.Func("sub", "scan only this path within the folder, can be repeated", func(s string) error {
+		subs = append(subs, s)
+		return nil
+	})
+	delay := fs.Duration("delay", 0, "run the next full scan of the folder after this delay")
+	wait := fs.Bool("wait", false, "wait until the scan is done, with --delay also the delayed one")
+	tmo := fs.Duration("wait_timeout", 30*time.Minute, "how long to wait")
+	pos, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return fmt.Errorf("usage: rescan <folder|all> [--sub path]... [--delay 60s] [--wait]")
+	}
+
+	// syncthing scans all folders for an empty ID
+	ids, fl := []string{""}, []cfgObj{}
+	if pos[0] == "all" {
+		if len(subs) > 0 || *delay > 0 {
+			return fmt.Errorf("--sub and --delay need a folder")
+		}
+		cfg, err := api.GetConfig()
+		if err != nil {
+			return err
+		}
+		for _, f := range cfgFolders(cfg) {
+			if !f.Paused {
+				fl = append(fl, f)
+			}
+		}
+	} else {
+		fl, err = resolveFolders(pos[0])
+		if err != nil {
+			return err
+		}
+		ids = []string{}
+		for _, f := range fl {
+			ids = append(ids, f.ID)
+		}
+	}
+
+	// the events are subscribed to before the scan, so its end is not missed
+	last := 0
+	if *wait {
+		ev, err := api.WaitEvents(context.Background(), "StateChanged", 0, 0)
+		if err != nil {
+			return err
+		}
+		if len(ev) > 0 {
+			last = ev[len(ev)-1].ID
+		}
+	}
+	// syncthing answers once the scan is done, the delay of the next full
+	// scan starts only then
+	t := time.Now()
+	from := map[string]time.Time{}
+	for _, f := range fl {
+		from[f.ID] = t
+	}
+	for _, id := range ids {
+		if err := api.Rescan(id, subs, *delay); err != nil {
+			return err
+		}
+		if *delay > 0 {
+			from[id] = time.Now().Add(*delay)
+		}
+	}
+	if !*wait {
+		return nil
+	}
+	return waitScan(fl, last, from, time.Now().Add(*delay+*tmo))
+}
+
+// waitScan waits until each folder in fl leaves the scanning state after
+// its time in from. Scans ending earlier, eg. the --sub one before the
+// delayed full scan, don't count.
+func waitScan(fl []cfgObj, last int, from map[string]time.Time, end time.Time) error {
+	todo := map[string]cfgObj{}
+	for _, f := range fl {
+		todo[f.ID] = f
+	}
+	for len(todo) > 0 {
+		w := time.Until(end)
+		if w <= 0 {
+			l := []string{}
+			for _, f := range todo {
+				l = append(l, f.String())
+			}
+			return fmt.Errorf("timed out waiting for scan of %v", l)
+		}
+		ev, err := api.WaitEvents(context.Background(), "StateChanged", last, max(min(w, time.Minute), time.Second))
+		if err != nil {
+			return err
+		}
+		for _, e := range ev {
+			last = e.ID
+			sc := struct {
+				Folder string `json:"folder"`
+				From   string `json:"from"`
+				To     string `json:"to"`
+			}{}
+			if json.Unmarshal(e.Data, &sc) != nil || sc.From != "scanning" || e.Time.Before(from[sc.Folder]) {
+				continue
+			}
+			if f, ok := todo[sc.Folder]; ok {
+				fmt.Printf("Scanned %v, now %v\n", f, sc.To)
+				delete(todo, sc.Folder)
+			}
+		}
+	}
+	return nil
+}
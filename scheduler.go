@@ -0,0 +1,321 @@
+// syncthing cli tool - time of day bandwidth and pause policies
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tenox7/stc/api"
+	"gopkg.in/yaml.v3"
+)
+
+// policy is a set of settings applied while it is active
+type policy struct {
+	Send          string                 `yaml:"send"`
+	Recv          string                 `yaml:"recv"`
+	Options       map[string]interface{} `yaml:"options"`
+	PauseDevices  []string               `yaml:"pause_devices"`
+	ResumeDevices []string               `yaml:"resume_devices"`
+	PauseFolders  []string               `yaml:"pause_folders"`
+	ResumeFolders []string               `yaml:"resume_folders"`
+}
+
+// rule is a policy active on given days between From and To, a range
+// where To is before From spans midnight
+type rule struct {
+	Name   string   `yaml:"name"`
+	Days   []string `yaml:"days"`
+	From   string   `yaml:"from"`
+	To     string   `yaml:"to"`
+	policy `yaml:",inline"`
+}
+
+// schedRules is the rules file, Default applies outside of any rule and
+// later rules override earlier ones
+type schedRules struct {
+	Interval time.Duration `yaml:"interval"`
+	Default  policy        `yaml:"default"`
+	Rules    []rule        `yaml:"rules"`
+}
+
+// schedState is the merged outcome of all active policies
+type schedState struct {
+	Rules   []string
+	Options map[string]interface{}
+	Devices map[string]bool
+	Folders map[string]bool
+}
+
+var weekDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func scheduler(args []string) error {
+	fs := flag.NewFlagSet("scheduler", flag.ExitOnError)
+	file := fs.String("rules", "", "rules yaml file")
+	once := fs.Bool("once", false, "apply currently active rules and exit")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("usage: scheduler --rules <rules.yaml> [--once]")
+	}
+	sr, err := loadRules(*file)
+	if err != nil {
+		return err
+	}
+	if *once {
+		return sr.state(time.Now()).apply()
+	}
+	return runScheduler(context.Background(), sr)
+}
+
+func loadRules(f string) (schedRules, error) {
+	b, err := os.ReadFile(f)
+	if err != nil {
+		return schedRules{}, err
+	}
+	sr := schedRules{}
+	d := yaml.NewDecoder(strings.NewReader(string(b)))
+	d.KnownFields(true)
+	if err := d.Decode(&sr); err != nil {
+		return schedRules{}, fmt.Errorf("%v: %w", f, err)
+	}
+	if sr.Interval <= 0 {
+		sr.Interval = time.Minute
+	}
+	if err := sr.Default.check(); err != nil {
+		return schedRules{}, fmt.Errorf("%v: default: %w", f, err)
+	}
+	for i, r := range sr.Rules {
+		n := r.Name
+		if n == "" {
+			n = fmt.Sprint(i + 1)
+			sr.Rules[i].Name = n
+		}
+		for _, d := range r.Days {
+			if !slices.Contains(weekDays, strings.ToLower(d)) {
+				return schedRules{}, fmt.Errorf("%v: rule %v: unknown day %q", f, n, d)
+			}
+		}
+		for _, t := range []string{r.From, r.To} {
+			if _, err := time.Parse("15:04", t); err != nil {
+				return schedRules{}, fmt.Errorf("%v: rule %v: time %q must be HH:MM", f, n, t)
+			}
+		}
+		if err := r.check(); err != nil {
+			return schedRules{}, fmt.Errorf("%v: rule %v: %w", f, n, err)
+		}
+	}
+	return sr, nil
+}
+
+func (p policy) check() error {
+	for _, r := range []string{p.Send, p.Recv} {
+		if r == "" {
+			continue
+		}
+		if _, err := parseRate(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// active reports whether rule applies at time t
+func (r rule) active(t time.Time) bool {
+	from, _ := time.Parse("15:04", r.From)
+	to, _ := time.Parse("15:04", r.To)
+	m := t.Hour()*60 + t.Minute()
+	fm := from.Hour()*60 + from.Minute()
+	tm := to.Hour()*60 + to.Minute()
+
+	// for ranges over midnight the day is the one on which it started
+	day := t.Weekday()
+	in := fm <= m && m < tm
+	if tm <= fm {
+		in = m >= fm || m < tm
+		if m < tm {
+			day = (day + 6) % 7
+		}
+	}
+	if !in {
+		return false
+	}
+	if len(r.Days) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(r.Days, func(d string) bool {
+		return strings.ToLower(d) == weekDays[day]
+	})
+}
+
+func (sr schedRules) state(t time.Time) schedState {
+	s := schedState{
+		Rules:   []string{},
+		Options: map[string]interface{}{},
+		Devices: map[string]bool{},
+		Folders: map[string]bool{},
+	}
+	s.merge(sr.Default)
+	for _, r := range sr.Rules {
+		if r.active(t) {
+			s.Rules = append(s.Rules, r.Name)
+			s.merge(r.policy)
+		}
+	}
+	return s
+}
+
+func (s *schedState) merge(p policy) {
+	maps.Copy(s.Options, p.Options)
+	if p.Send != "" {
+		s.Options["maxSendKbps"], _ = parseRate(p.Send)
+	}
+	if p.Recv != "" {
+		s.Options["maxRecvKbps"], _ = parseRate(p.Recv)
+	}
+	for l, v := range map[*[]string]bool{&p.PauseDevices: true, &p.ResumeDevices: false} {
+		for _, d := range *l {
+			s.Devices[d] = v
+		}
+	}
+	for l, v := range map[*[]string]bool{&p.PauseFolders: true, &p.ResumeFolders: false} {
+		for _, f := range *l {
+			s.Folders[f] = v
+		}
+	}
+}
+
+// apply sets options and pauses or resumes devices and folders which are
+// not in the desired state already
+func (s schedState) apply() error {
+	if len(s.Options) > 0 {
+		o, err := getOptions()
+		if err != nil {
+			return err
+		}
+		p := map[string]interface{}{}
+		for k, v := range s.Options {
+			cur, ok := o[k]
+			if !ok {
+				return fmt.Errorf("unknown option %q", k)
+			}
+			if !sameKind(cur, v) {
+				return fmt.Errorf("option %v: %v is not of type %T", k, jsonStr(v), cur)
+			}
+			if jsonStr(cur) != jsonStr(v) {
+				p[k] = v
+				log.Printf("setting %v to %v", k, jsonStr(v))
+			}
+		}
+		if len(p) > 0 {
+			if err := api.PatchOptions(p); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(s.Devices) == 0 && len(s.Folders) == 0 {
+		return nil
+	}
+	cfg, err := api.GetConfig()
+	if err != nil {
+		return err
+	}
+	for _, n := range slices.Sorted(maps.Keys(s.Devices)) {
+		dl, err := findObjs("device", n, cfgDevices(cfg))
+		if err != nil {
+			return err
+		}
+		for _, d := range dl {
+			if d.Paused == s.Devices[n] {
+				continue
+			}
+			log.Printf("%v device %v", pauseStr(s.Devices[n]), d)
+			if err := api.PauseDevice(d.ID, s.Devices[n]); err != nil {
+				return err
+			}
+		}
+	}
+	for _, n := range slices.Sorted(maps.Keys(s.Folders)) {
+		fl, err := findObjs("folder", n, cfgFolders(cfg))
+		if err != nil {
+			return err
+		}
+		for _, f := range fl {
+			if f.Paused == s.Folders[n] {
+				continue
+			}
+			log.Printf("%v folder %v", pauseStr(s.Folders[n]), f)
+			if err := api.PauseFolder(f.ID, s.Folders[n]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func pauseStr(p bool) string {
+	if p {
+		return "pausing"
+	}
+	return "resuming"
+}
+
+// sameKind compares json types of two values
+func sameKind(a, b interface{}) bool {
+	var x interface{}
+	if err := json.Unmarshal([]byte(jsonStr(b)), &x); err != nil {
+		return false
+	}
+	return reflect.TypeOf(a) == reflect.TypeOf(x)
+}
+
+// runScheduler applies the state whenever the set of active rules changes,
+// on start and after syncthing restarts, which reset its uptime
+func runScheduler(ctx context.Context, sr schedRules) error {
+	last := ""
+	up := int64(0)
+	t := time.NewTicker(sr.Interval)
+	defer t.Stop()
+	for {
+		st, err := api.GetSysStatus()
+		if err != nil {
+			slog.Error("scheduler", "err", err)
+		} else {
+			s := sr.state(time.Now())
+			k := jsonStr(s)
+			if st.Uptime < up {
+				log.Print("syncthing restart detected")
+				last = ""
+			}
+			up = st.Uptime
+			if k != last {
+				r := strings.Join(s.Rules, ", ")
+				if r == "" {
+					r = "default"
+				}
+				log.Printf("active rules: %v", r)
+				if err := s.apply(); err != nil {
+					slog.Error("scheduler", "err", err)
+				} else {
+					last = k
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// syncthing cli tool - systemd service notifications
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdNotify sends a state to systemd, if running as Type=notify service
+func sdNotify(state string) {
+	s := os.Getenv("NOTIFY_SOCKET")
+	if s == "" {
+		return
+	}
+	if strings.HasPrefix(s, "@") {
+		s = "\x00" + s[1:]
+	}
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: s, Net: "unixgram"})
+	if err != nil {
+		slog.Error("sd_notify failed", "err", err)
+		return
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte(state)); err != nil {
+		slog.Error("sd_notify failed", "err", err)
+	}
+}
+
+// sdReloading tells systemd the config is being reloaded, Type=notify-reload
+// services must send the time of the reload along
+func sdReloading() {
+	sdNotify(fmt.Sprintf("RELOADING=1\nMONOTONIC_USEC=%d", monotonicUsec()))
+}
+
+// sdWatchdog returns how often to ping the systemd watchdog, an hour if
+// WatchdogSec is not set for this process
+func sdWatchdog() time.Duration {
+	us, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || us <= 0 {
+		return time.Hour
+	}
+	if p := os.Getenv("WATCHDOG_PID"); p != "" && p != strconv.Itoa(os.Getpid()) {
+		return time.Hour
+	}
+	return time.Duration(us) * time.Microsecond / 2
+}
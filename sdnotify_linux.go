@@ -0,0 +1,13 @@
+// syncthing cli tool - systemd service notifications
+package main
+
+import "golang.org/x/sys/unix"
+
+// monotonicUsec is CLOCK_MONOTONIC in microseconds, as systemd expects it
+func monotonicUsec() int64 {
+	ts := unix.Timespec{}
+	if unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts) != nil {
+		return 0
+	}
+	return ts.Nano() / 1000
+}
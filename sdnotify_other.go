@@ -0,0 +1,9 @@
+//go:build !linux
+
+// syncthing cli tool - systemd service notifications
+package main
+
+// monotonicUsec is only used with systemd, which is linux only
+func monotonicUsec() int64 {
+	return 0
+}
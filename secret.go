@@ -0,0 +1,160 @@
+// syncthing cli tool - api key storage in os keyring
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/term"
+)
+
+const keyringService = "stc"
+
+// keyFile lists the targets with a stored api key. An empty value means the
+// key is in the keyring, otherwise the file holds the key itself, where no
+// keyring is available such as headless machines without Secret Service.
+func keyFile() (string, error) {
+	d, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d, "stc", "apikeys.json"), nil
+}
+
+func readKeyFile() (map[string]string, error) {
+	f, err := keyFile()
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]string{}
+	b, err := os.ReadFile(f)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%v: %w", f, err)
+	}
+	return m, nil
+}
+
+func writeKeyFile(m map[string]string) error {
+	f, err := keyFile()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(f, b, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(f, 0600)
+}
+
+// keyName is the name a key for target is stored under. Over ssh the target
+// is usually the loopback address of the remote host, so the host is part of
+// the name, as for the session cache.
+func keyName(target string) string {
+	if *sshHost == "" {
+		return target
+	}
+	return *sshHost + " " + target
+}
+
+// keyGet returns api key stored for a target, or empty string if none. The
+// keyring is only asked for targets listed in keyFile, as it may prompt to
+// unlock it or start a D-Bus session.
+func keyGet(target string) (string, error) {
+	m, err := readKeyFile()
+	if err != nil {
+		return "", err
+	}
+	n := keyName(target)
+	k, ok := m[n]
+	if !ok || k != "" {
+		return k, nil
+	}
+	return keyring.Get(keyringService, n)
+}
+
+// keySet stores api key for a target in the keyring, or the fallback file
+// if keyring is not available
+func keySet(target, key string) error {
+	m, err := readKeyFile()
+	if err != nil {
+		return err
+	}
+	n := keyName(target)
+	m[n] = key
+	if keyring.Set(keyringService, n, key) == nil {
+		m[n] = ""
+	}
+	return writeKeyFile(m)
+}
+
+func keyDel(target string) error {
+	m, err := readKeyFile()
+	if err != nil {
+		return err
+	}
+	n := keyName(target)
+	k, ok := m[n]
+	if !ok {
+		return fmt.Errorf("no api key stored for %v", n)
+	}
+	if k == "" {
+		if err := keyring.Delete(keyringService, n); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+			return err
+		}
+	}
+	delete(m, n)
+	return writeKeyFile(m)
+}
+
+// apiKeyStore saves api key read from stdin, so it never appears in shell
+// history or process list
+func apiKeyStore(target string) error {
+	if target == "" {
+		return fmt.Errorf("target must be specified")
+	}
+	var k string
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintf(os.Stderr, "API Key for %v: ", target)
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return err
+		}
+		k = string(b)
+	} else {
+		k, _ = bufio.NewReader(os.Stdin).ReadString('\n')
+	}
+	k = strings.TrimSpace(k)
+	if k == "" {
+		return fmt.Errorf("empty api key")
+	}
+	return keySet(target, k)
+}
+
+// readKeyFlagFile returns the api key from first line of a file
+func readKeyFlagFile(f string) (string, error) {
+	b, err := os.ReadFile(f)
+	if err != nil {
+		return "", err
+	}
+	k, _, _ := strings.Cut(string(b), "\n")
+	return strings.TrimSpace(k), nil
+}
@@ -0,0 +1,229 @@
+// syncthing cli tool - email digest notifications
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tenox7/stc/api"
+)
+
+type smtpConfig struct {
+	Server   string        `yaml:"server"`
+	TLS      string        `yaml:"tls"`
+	User     string        `yaml:"user"`
+	Password string        `yaml:"password"`
+	From     string        `yaml:"from"`
+	To       []string      `yaml:"to"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// smtpSink mails notices as a digest, at most one mail per interval.
+// Notices arriving in between are collected for the next mail.
+type smtpSink struct {
+	cfg     smtpConfig
+	mu      sync.Mutex
+	pending []notice
+	last    time.Time
+	timer   *time.Timer
+}
+
+// notices kept for a digest, older ones are dropped if mail can't be sent
+const maxPending = 1000
+
+func newSmtpSink(c smtpConfig) (*smtpSink, error) {
+	if _, _, err := net.SplitHostPort(c.Server); err != nil {
+		return nil, fmt.Errorf("smtp server %q must be host:port", c.Server)
+	}
+	switch c.TLS {
+	case "":
+		c.TLS = "starttls"
+	case "starttls", "tls", "none":
+	default:
+		return nil, fmt.Errorf("unknown smtp tls mode %q, use starttls, tls or none", c.TLS)
+	}
+	if c.From == "" || len(c.To) == 0 {
+		return nil, fmt.Errorf("smtp sender and recipients must be specified")
+	}
+	if c.Password == "" {
+		c.Password = os.Getenv("STC_SMTP_PASSWORD")
+	}
+	if c.Interval <= 0 {
+		c.Interval = 15 * time.Minute
+	}
+	return &smtpSink{cfg: c}, nil
+}
+
+func (s *smtpSink) send(n notice) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, n)
+	if len(s.pending) > maxPending {
+		s.pending = s.pending[len(s.pending)-maxPending:]
+	}
+	if s.timer != nil {
+		return nil
+	}
+	d := time.Until(s.last.Add(s.cfg.Interval))
+	if d <= 0 {
+		return s.flush()
+	}
+	s.timer = time.AfterFunc(d, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.timer = nil
+		if err := s.flush(); err != nil {
+			log.Print(err)
+		}
+	})
+	return nil
+}
+
+// close mails pending notices right away
+func (s *smtpSink) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	return s.flush()
+}
+
+// flush mails pending notices, must be called with mu held
+func (s *smtpSink) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	s.last = time.Now()
+	host := s.pending[0].Host
+	subj := fmt.Sprintf("[stc] %v: %v notifications", host, len(s.pending))
+	if len(s.pending) == 1 {
+		subj = fmt.Sprintf("[stc] %v: %v", host, s.pending[0].Message)
+	}
+	if err := s.mail(subj, digest(s.pending)); err != nil {
+		return fmt.Errorf("smtp %v: %w", s.cfg.Server, err)
+	}
+	s.pending = nil
+	return nil
+}
+
+// digest lists the notices followed by the current problems
+func digest(ns []notice) string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "Notifications:\n")
+	for _, n := range ns {
+		fmt.Fprintf(b, "  %v  %v\n", n.Time.Format("2006-01-02 15:04:05"), n.Message)
+	}
+
+	s, err := getSnapshot()
+	if err != nil {
+		fmt.Fprintf(b, "\nUnable to get current state: %v\n", err)
+		return b.String()
+	}
+	off := []string{}
+	for _, d := range s.Devices {
+		if d.Status == "Offline" {
+			off = append(off, d.Name)
+		}
+	}
+	if len(off) > 0 {
+		fmt.Fprintf(b, "\nOffline devices:\n  %v\n", strings.Join(off, "\n  "))
+	}
+	for _, f := range s.Folders {
+		if f.Status != "Errors" {
+			continue
+		}
+		fe, err := api.GetFolderErrors(f.ID)
+		if err != nil {
+			fmt.Fprintf(b, "\nFolder %v errors: %v\n", f.Name, err)
+			continue
+		}
+		fmt.Fprintf(b, "\nFolder %v errors:\n", f.Name)
+		for i, e := range fe.Errors {
+			if i == 20 {
+				fmt.Fprintf(b, "  ... and %v more\n", len(fe.Errors)-i)
+				break
+			}
+			fmt.Fprintf(b, "  %v: %v\n", e.Path, e.Error)
+		}
+	}
+	se, err := api.GetSysErrors()
+	if err == nil && len(se.Errors) > 0 {
+		fmt.Fprintf(b, "\nSystem errors:\n")
+		for _, e := range se.Errors {
+			fmt.Fprintf(b, "  %v  %v\n", e.When, e.Message)
+		}
+	}
+	return b.String()
+}
+
+func (s *smtpSink) mail(subj, body string) error {
+	host, _, _ := net.SplitHostPort(s.cfg.Server)
+	tc := &tls.Config{ServerName: host}
+	d := &net.Dialer{Timeout: 30 * time.Second}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.cfg.TLS == "tls" {
+		conn, err = tls.DialWithDialer(d, "tcp", s.cfg.Server, tc)
+	} else {
+		conn, err = d.Dial("tcp", s.cfg.Server)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Minute))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if s.cfg.TLS == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("server does not support STARTTLS")
+		}
+		if err := c.StartTLS(tc); err != nil {
+			return err
+		}
+	}
+	if s.cfg.User != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.cfg.User, s.cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.cfg.From); err != nil {
+		return err
+	}
+	for _, t := range s.cfg.To {
+		if err := c.Rcpt(t); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "From: %v\r\nTo: %v\r\nSubject: %v\r\nDate: %v\r\n"+
+		"MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%v",
+		s.cfg.From,
+		strings.Join(s.cfg.To, ", "),
+		strings.NewReplacer("\r", " ", "\n", " ").Replace(subj),
+		time.Now().Format(time.RFC1123Z),
+		body,
+	)
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
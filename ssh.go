@@ -0,0 +1,117 @@
+// syncthing cli tool - ssh tunnel to remote targets
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	osuser "os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/tenox7/stc/api"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var (
+	sshc *ssh.Client
+)
+
+// sshConnect opens ssh connection to [user@]host[:port] authenticating with
+// ssh agent or unencrypted default keys, verifying the host in known_hosts.
+// All API connections are then tunneled through it.
+func sshConnect(dst string) error {
+	u, h, ok := strings.Cut(dst, "@")
+	if !ok {
+		h = u
+		cu, err := osuser.Current()
+		if err != nil {
+			return err
+		}
+		u = cu.Username
+	}
+	if _, _, err := net.SplitHostPort(h); err != nil {
+		h = net.JoinHostPort(h, "22")
+	}
+
+	hd, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	hk, err := knownhosts.New(filepath.Join(hd, ".ssh", "known_hosts"))
+	if err != nil {
+		return fmt.Errorf("ssh known_hosts: %w", err)
+	}
+
+	am := []ssh.AuthMethod{}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if ac, err := net.Dial("unix", sock); err == nil {
+			am = append(am, ssh.PublicKeysCallback(agent.NewClient(ac).Signers))
+		}
+	}
+	sg := []ssh.Signer{}
+	for _, k := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		b, err := os.ReadFile(filepath.Join(hd, ".ssh", k))
+		if err != nil {
+			continue
+		}
+		s, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			continue
+		}
+		sg = append(sg, s)
+	}
+	if len(sg) > 0 {
+		am = append(am, ssh.PublicKeys(sg...))
+	}
+
+	sshc, err = ssh.Dial("tcp", h, &ssh.ClientConfig{
+		User:            u,
+		Auth:            am,
+		HostKeyCallback: hk,
+	})
+	if err != nil {
+		return fmt.Errorf("ssh %v: %w", dst, err)
+	}
+
+	api.SetDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return sshc.DialContext(ctx, network, addr)
+	})
+	return nil
+}
+
+// sshHomeFile reads a file from syncthing home directory on the remote
+// host, searching the default locations unless homeDir is specified
+func sshHomeFile(homeDir, name string) ([]byte, string, error) {
+	dirs := []string{
+		`"$HOME/.local/state/syncthing"`,
+		`"$HOME/.config/syncthing"`,
+		`"$HOME/Library/Application Support/Syncthing"`,
+	}
+	if homeDir != "" {
+		dirs = []string{shQuote(homeDir)}
+	}
+	cmd := "for d in " + strings.Join(dirs, " ") + "; do " +
+		"if [ -f \"$d/config.xml\" ]; then echo \"$d\"; cat \"$d/\"" + shQuote(name) + "; exit; fi; " +
+		"done; exit 1"
+
+	s, err := sshc.NewSession()
+	if err != nil {
+		return nil, "", err
+	}
+	defer s.Close()
+	o, err := s.Output(cmd)
+	if err != nil {
+		return nil, "", fmt.Errorf("%v not found on remote host: %w", name, os.ErrNotExist)
+	}
+	d, b, _ := bytes.Cut(o, []byte("\n"))
+	return b, string(d) + "/" + name, nil
+}
+
+func shQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
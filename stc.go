@@ -18,16 +18,30 @@ import (
 
 var (
 	apiKey  = flag.String("apikey", "", "Syncthing API Key")
+	keyFlFl = flag.String("apikey_file", "", "File containing Syncthing API Key")
+	keyCmd  = flag.String("apikey_command", "", "Command printing Syncthing API Key, eg. 'pass show syncthing'")
 	target  = flag.String("target", "", "Syncthing Target URL")
+	user    = flag.String("user", "", "Syncthing GUI user, used instead of API Key")
+	passwd  = flag.String("password", "", "Syncthing GUI password")
 	homeDir = flag.String("homedir", "", "Syncthing Home Directory, used to get API Key and Target")
+	sshHost = flag.String("ssh", "", "Tunnel to Syncthing on a remote [user@]host[:port] over ssh")
 	limit   = flag.Int("limit", -1, "Limit of items to return when returning lists")
 	since   = flag.Int("since", 0, "ID of item to start from when returning lists")
 	igCert  = flag.Bool("ignore_cert_errors", false, "ignore https/ssl/tls cert errors")
+	caCert  = flag.String("cacert", "", "PEM file with CA certificates to trust for https targets")
+	pin     = flag.String("pin", "", "Accept only https server certificate with this fingerprint (sha256:hex)")
+	clCert  = flag.String("client_cert", "", "PEM client certificate for https targets requiring mutual TLS")
+	clKey   = flag.String("client_key", "", "PEM client certificate key, if not included in client_cert file")
+	profNam = flag.String("profile", "", "Named connection profile from stc config file, default $STC_PROFILE")
+	output  = flag.String("output", "table", "Dashboard output format: table or json")
+	timeout = flag.Duration("timeout", 0, "Timeout of API requests, default 1m, events, scans and upgrades are not limited")
+	concur  = flag.Int("concurrency", 8, "Number of parallel API requests when collecting status")
 	verFlag = flag.Bool("version", false, "print version")
 	GitTag  string
 )
 
 type SyncFolder struct {
+	ID     string  `json:"folderID"`
 	Name   string  `json:"folderName"`
 	Status string  `json:"status"`
 	Sync   float64 `json:"syncPercentDone"`
@@ -37,6 +51,7 @@ type SyncFolder struct {
 }
 
 type SyncDevice struct {
+	ID       string  `json:"deviceID"`
 	Name     string  `json:"deviceName"`
 	Status   string  `json:"status"`
 	Sync     float64 `json:"syncPercentDone"`
@@ -45,36 +60,108 @@ type SyncDevice struct {
 	Needs    uint64  `json:"missingBytes"`
 }
 
-func dash() error {
-	dumpErrors(true)
+// snapshot is a single collection of everything needed to render the
+// dashboard, shared by the table and json outputs
+type snapshot struct {
+	MyName  string
+	Status  api.SysStatus
+	Version api.SysVersion
+	Folders []SyncFolder
+	Devices []SyncDevice
+}
 
+func getSnapshot() (snapshot, error) {
 	cfg, err := api.GetConfig()
 	if err != nil {
-		return err
+		return snapshot{}, err
 	}
 
-	st, err := api.GetSysStatus()
-	if err != nil {
-		return err
+	s := snapshot{
+		Folders: make([]SyncFolder, len(cfg.Folders)),
+		Devices: make([]SyncDevice, len(cfg.Devices)),
 	}
+	var cons api.SysConn
 
-	sv, err := api.GetSysVersion()
-	if err != nil {
+	// system wide calls are independent, fetch them alongside each other
+	err = parallel(*concur, 3, func(i int) error {
+		var err error
+		switch i {
+		case 0:
+			s.Status, err = api.GetSysStatus()
+		case 1:
+			s.Version, err = api.GetSysVersion()
+		case 2:
+			cons, err = api.GetConnection()
+		}
 		return err
+	})
+	if err != nil {
+		return snapshot{}, err
 	}
 
-	myName := ""
 	for _, n := range cfg.Devices {
-		if n.DeviceID != st.MyID {
+		if n.DeviceID != s.Status.MyID {
 			continue
 		}
-		myName = n.Name
-	}
-	if myName == "" {
-		return fmt.Errorf("unable to find this device name")
+		s.MyName = n.Name
+	}
+	if s.MyName == "" {
+		return snapshot{}, fmt.Errorf("unable to find this device name")
+	}
+
+	nf := len(cfg.Folders)
+	err = parallel(*concur, nf+len(cfg.Devices), func(i int) error {
+		if i < nf {
+			f := cfg.Folders[i]
+			fs, err := api.GetFolderStatus(f.ID)
+			if err != nil {
+				return err
+			}
+			co, err := api.GetCompletion("folder=" + f.ID)
+			if err != nil {
+				return err
+			}
+			s.Folders[i] = SyncFolder{
+				ID:     f.ID,
+				Name:   f.Label,
+				Status: fStatus(f.Paused, f.Type, fs.State, fs.Errors, fs.ReceiveOnlyTotalItems, fs.NeedTotalItems),
+				Sync:   co.Completion,
+				Global: fs.GlobalBytes,
+				Local:  fs.LocalBytes,
+				Needs:  fs.NeedBytes,
+			}
+			return nil
+		}
+		d := cfg.Devices[i-nf]
+		co, err := api.GetCompletion("device=" + d.DeviceID)
+		if err != nil {
+			return err
+		}
+		if d.Name == s.MyName {
+			d.Name = "*" + d.Name
+		}
+		s.Devices[i-nf] = SyncDevice{
+			ID:       d.DeviceID,
+			Name:     d.Name,
+			Status:   isConn(d.Paused, cons[d.DeviceID].Connected, d.DeviceID, s.Status.MyID),
+			Sync:     co.Completion,
+			Download: cons[d.DeviceID].InBytesTotal,
+			Upload:   cons[d.DeviceID].OutBytesTotal,
+			Needs:    co.NeedBytes,
+		}
+		return nil
+	})
+	if err != nil {
+		return snapshot{}, err
 	}
 
-	cons, err := api.GetConnection()
+	return s, nil
+}
+
+func dash() error {
+	dumpErrors(true)
+
+	s, err := getSnapshot()
 	if err != nil {
 		return err
 	}
@@ -83,29 +170,21 @@ func dash() error {
 
 	fmt.Fprintf(t, "Host\tUptime\tVersion\n")
 	fmt.Fprintf(t, "%v\t%v\t%v\n",
-		myName,
-		durafmt.ParseShort(time.Duration(st.Uptime*1000000000)),
-		sv.Version,
+		s.MyName,
+		durafmt.ParseShort(time.Duration(s.Status.Uptime*1000000000)),
+		s.Version.Version,
 	)
 
 	fmt.Fprintf(t, "\nFolder\tStatus\tSync\tGlobal\tLocal\tNeeds\n")
 
-	for _, f := range cfg.Folders {
-		fs, err := api.GetFolderStatus(f.ID)
-		if err != nil {
-			return err
-		}
-		co, err := api.GetCompletion("folder=" + f.ID)
-		if err != nil {
-			return err
-		}
+	for _, f := range s.Folders {
 		fmt.Fprintf(t, "%v\t%v\t%5.1f%%\t%v\t%v\t%v\n",
-			f.Label,
-			fStatus(f.Paused, f.Type, fs.State, fs.Errors, fs.ReceiveOnlyTotalItems, fs.NeedTotalItems),
-			co.Completion,
-			humanize.Bytes(fs.GlobalBytes),
-			humanize.Bytes(fs.LocalBytes),
-			humanize.Bytes(fs.NeedBytes),
+			f.Name,
+			f.Status,
+			f.Sync,
+			humanize.Bytes(f.Global),
+			humanize.Bytes(f.Local),
+			humanize.Bytes(f.Needs),
 		)
 	}
 
@@ -113,23 +192,14 @@ func dash() error {
 
 	fmt.Fprintf(t, "\nDevice\tStatus\tSync\tDownload\tUpload\tNeeds\n")
 
-	for _, d := range cfg.Devices {
-		co, err := api.GetCompletion("device=" + d.DeviceID)
-		if err != nil {
-			return err
-		}
-
-		if d.Name == myName {
-			d.Name = "*" + d.Name
-		}
-
+	for _, d := range s.Devices {
 		fmt.Fprintf(t, "%v\t%v\t%5.1f%%\t%v\t%v\t%v\n",
 			d.Name,
-			isConn(d.Paused, cons[d.DeviceID].Connected, d.DeviceID, st.MyID),
-			co.Completion,
-			humanize.Bytes(cons[d.DeviceID].InBytesTotal),
-			humanize.Bytes(cons[d.DeviceID].OutBytesTotal),
-			humanize.Bytes(co.NeedBytes),
+			d.Status,
+			d.Sync,
+			humanize.Bytes(d.Download),
+			humanize.Bytes(d.Upload),
+			humanize.Bytes(d.Needs),
 		)
 	}
 
@@ -138,110 +208,8 @@ func dash() error {
 	return nil
 }
 
-func getFolderInfoAsStruct(cfg api.StConfig) ([]SyncFolder, error) {
-	dumpErrors(true)
-
-	st, err := api.GetSysStatus()
-	if err != nil {
-		return nil, err
-	}
-
-	myName := ""
-	for _, n := range cfg.Devices {
-		if n.DeviceID != st.MyID {
-			continue
-		}
-		myName = n.Name
-	}
-	if myName == "" {
-		return nil, fmt.Errorf("unable to find this device name")
-	}
-
-	folders := []SyncFolder{}
-
-	for _, f := range cfg.Folders {
-		fs, err := api.GetFolderStatus(f.ID)
-		if err != nil {
-			return nil, err
-		}
-		co, err := api.GetCompletion("folder=" + f.ID)
-		if err != nil {
-			return nil, err
-		}
-		folders = append(folders,
-			SyncFolder{
-				Name:   f.Label,
-				Status: fStatus(f.Paused, f.Type, fs.State, fs.Errors, fs.ReceiveOnlyTotalItems, fs.NeedTotalItems),
-				Sync:   co.Completion,
-				Global: fs.GlobalBytes,
-				Local:  fs.LocalBytes,
-				Needs:  fs.NeedBytes,
-			})
-	}
-
-	return folders, nil
-}
-
-func getDeviceInfoAsStruct(cfg api.StConfig) ([]SyncDevice, error) {
-
-	st, err := api.GetSysStatus()
-	if err != nil {
-		return nil, err
-	}
-
-	myName := ""
-	for _, n := range cfg.Devices {
-		if n.DeviceID != st.MyID {
-			continue
-		}
-		myName = n.Name
-	}
-	if myName == "" {
-		return nil, fmt.Errorf("unable to find this device name")
-	}
-
-	cons, err := api.GetConnection()
-	if err != nil {
-		return nil, err
-	}
-
-	devices := []SyncDevice{}
-
-	for _, d := range cfg.Devices {
-		co, err := api.GetCompletion("device=" + d.DeviceID)
-		if err != nil {
-			return nil, err
-		}
-
-		if d.Name == myName {
-			d.Name = "*" + d.Name
-		}
-		devices = append(devices,
-			SyncDevice{
-				Name:     d.Name,
-				Status:   isConn(d.Paused, cons[d.DeviceID].Connected, d.DeviceID, st.MyID),
-				Sync:     co.Completion,
-				Download: cons[d.DeviceID].InBytesTotal,
-				Upload:   cons[d.DeviceID].OutBytesTotal,
-				Needs:    co.NeedBytes,
-			})
-	}
-
-	return devices, nil
-}
-
 func dumpDashAsJson() error {
-
-	cfg, err := api.GetConfig()
-	if err != nil {
-		return err
-	}
-
-	devices, err := getDeviceInfoAsStruct(cfg)
-	if err != nil {
-		return err
-	}
-	folders, err := getFolderInfoAsStruct(cfg)
+	s, err := getSnapshot()
 	if err != nil {
 		return err
 	}
@@ -250,13 +218,12 @@ func dumpDashAsJson() error {
 		Folders []SyncFolder `json:"folders"`
 		Devices []SyncDevice `json:"devices"`
 	}{
-		Folders: folders,
-		Devices: devices,
+		Folders: s.Folders,
+		Devices: s.Devices,
 	}
 
 	jsonData, err := json.Marshal(output)
 	if err != nil {
-		fmt.Println("Error:", err)
 		return err
 	}
 
@@ -296,51 +263,117 @@ func dumpMyID() error {
 	return nil
 }
 
-func rescan(fName string) error {
-	if fName == "all" {
-		return api.Rescan("")
+// folderPause pauses or resumes all folders matching fName
+func folderPause(fName string, p bool) error {
+	fl, err := resolveFolders(fName)
+	if err != nil {
+		return err
 	}
-	fID, err := folderID(fName)
+	for _, f := range fl {
+		if err := api.PauseFolder(f.ID, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func override(args []string) error {
+	fs := flag.NewFlagSet("override", flag.ExitOnError)
+	yes, dry := guardFlags(fs)
+	pos, err := parseArgs(fs, args)
 	if err != nil {
 		return err
 	}
-	if fID == "" {
-		return fmt.Errorf("folder %q not found, use 'all' to rescan all folders", fName)
+	if len(pos) != 1 {
+		return fmt.Errorf("usage: override <folder> [--yes] [--dry_run]")
 	}
-	return api.Rescan(fID)
+	f, err := resolveFolder(pos[0])
+	if err != nil {
+		return err
+	}
+	ok, err := guard(fmt.Sprintf("override remote changes in folder %v, making local files the global version", f), *yes, *dry)
+	if !ok {
+		return err
+	}
+	return api.Override(f.ID)
 }
 
-func override(fName string) error {
-	fID, err := folderID(fName)
+func revert(args []string) error {
+	fs := flag.NewFlagSet("revert", flag.ExitOnError)
+	yes, dry := guardFlags(fs)
+	pos, err := parseArgs(fs, args)
 	if err != nil {
 		return err
 	}
-	if fID == "" {
-		return fmt.Errorf("folder %q not found", fName)
+	if len(pos) != 1 {
+		return fmt.Errorf("usage: revert <folder> [--yes] [--dry_run]")
 	}
-	return api.Override(fID)
+	f, err := resolveFolder(pos[0])
+	if err != nil {
+		return err
+	}
+	ok, err := guard(fmt.Sprintf("revert local changes in folder %v, deleting local additions and restoring global versions", f), *yes, *dry)
+	if !ok {
+		return err
+	}
+	return api.Revert(f.ID)
 }
 
-func revert(fName string) error {
-	fID, err := folderID(fName)
+// resetDB resets index of a folder, or of all folders if none is given
+func resetDB(args []string) error {
+	fs := flag.NewFlagSet("reset_db", flag.ExitOnError)
+	yes, dry := guardFlags(fs)
+	pos, err := parseArgs(fs, args)
 	if err != nil {
 		return err
 	}
-	if fID == "" {
-		return fmt.Errorf("folder %q not found", fName)
+	if len(pos) > 1 {
+		return fmt.Errorf("usage: reset_db [folder] [--yes] [--dry_run]")
+	}
+	fID, what := "", "reset the database of all folders, syncthing restarts and rescans everything"
+	if len(pos) == 1 {
+		f, err := resolveFolder(pos[0])
+		if err != nil {
+			return err
+		}
+		fID = f.ID
+		what = fmt.Sprintf("reset the database of folder %v, syncthing restarts and rescans it", f)
+	}
+	ok, err := guard(what, *yes, *dry)
+	if !ok {
+		return err
 	}
-	return api.Revert(fID)
+	return api.ResetDB(fID)
 }
 
-func folderErrors(fName string) error {
-	fID, err := folderID(fName)
+// folderRemove removes folder from config, files on disk are kept
+func folderRemove(args []string) error {
+	fs := flag.NewFlagSet("folder_remove", flag.ExitOnError)
+	yes, dry := guardFlags(fs)
+	pos, err := parseArgs(fs, args)
 	if err != nil {
 		return err
 	}
-	if fID == "" {
-		return fmt.Errorf("folder %q not found", fName)
+	if len(pos) != 1 {
+		return fmt.Errorf("usage: folder_remove <folder> [--yes] [--dry_run]")
 	}
-	fe, err := api.GetFolderErrors(fID)
+	f, err := resolveFolder(pos[0])
+	if err != nil {
+		return err
+	}
+	ok, err := guard(fmt.Sprintf("remove folder %v from config, stopping its sync, files on disk are kept", f), *yes, *dry)
+	if !ok {
+		return err
+	}
+	return api.DeleteConfigObject("folders", f.ID)
+}
+
+func folderErrors(fName string) error {
+	f, err := resolveFolder(fName)
+	if err != nil {
+		return err
+	}
+	fe, err := api.GetFolderErrors(f.ID)
 	if err != nil {
 		return err
 	}
@@ -360,6 +393,35 @@ func events(event_types string, limit int, since int) error {
 	return nil
 }
 
+// localCmd runs commands that don't need a connection to syncthing api,
+// returning false if the command is not one of them
+func localCmd() (bool, error) {
+	switch flag.Arg(0) {
+	case "apikey_store", "apikey_delete":
+		t := flag.Arg(1)
+		if t == "" {
+			t = *target
+		}
+		if flag.Arg(0) == "apikey_store" {
+			return true, apiKeyStore(t)
+		}
+		if t == "" {
+			return true, fmt.Errorf("target must be specified")
+		}
+		return true, keyDel(t)
+	case "offline_config":
+		return true, offlineConfig(*homeDir, flag.Args()[1:])
+	case "config_diff":
+		switch {
+		case flag.NArg() < 2:
+			return true, fmt.Errorf("usage: config_diff <a> [b]")
+		case flag.NArg() > 2:
+			return true, configDiff(flag.Arg(1), flag.Arg(2))
+		}
+	}
+	return false, nil
+}
+
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	flag.Usage = usage
@@ -369,29 +431,71 @@ func main() {
 		os.Exit(0)
 	}
 
-	a, t, err := cfg(*apiKey, *target, *homeDir)
+	// profile management doesn't need a connection to syncthing
+	if flag.Arg(0) == "profile" && flag.Arg(1) != "test" {
+		if err := profileCmd(flag.Arg(1), flag.Args()[min(2, flag.NArg()):]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	pn := *profNam
+	if flag.Arg(0) == "profile" && flag.Arg(2) != "" {
+		pn = flag.Arg(2)
+	}
+	if err := applyProfile(pn); err != nil {
+		log.Fatal(err)
+	}
+
+	api.SetTimeout(*timeout)
+
+	if *sshHost != "" {
+		if err := sshConnect(*sshHost); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if ok, err := localCmd(); ok {
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	u, p := creds(*user, *passwd)
+	a, t, err := cfg(*apiKey, *keyFlFl, *keyCmd, *target, *homeDir, u != "")
 	if err != nil {
 		log.Fatal("apikey and target flags not specified, config file: ", err)
 	}
 
-	err = api.SetApiKeyTarget(a, t)
+	if *igCert {
+		api.IgnoreCertErrors()
+	}
+	err = tlsSetup(*homeDir, t, *caCert, *pin, *clCert, *clKey)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if *igCert {
-		api.IgnoreCertErrors()
+	if a == "" {
+		err = api.SetUserTarget(u, t)
+		if err == nil {
+			err = api.Login(u, p, sessionFile(u, *sshHost+t))
+		}
+	} else {
+		err = api.SetApiKeyTarget(a, t)
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	switch flag.Arg(0) {
 	case "log":
-		err = dumpLogTxt()
+		err = showLog(flag.Args()[1:])
 	case "shutdown":
-		err = api.Shutdown()
+		err = shutdown(flag.Args()[1:])
 	case "restart":
-		err = api.Restart()
+		err = restart(flag.Args()[1:])
 	case "reset_db":
-		err = api.ResetDB()
+		err = resetDB(flag.Args()[1:])
 	case "errors":
 		err = dumpErrors(false)
 	case "clear_errors":
@@ -402,22 +506,69 @@ func main() {
 		err = folderErrors(flag.Arg(1))
 	case "id":
 		err = dumpMyID()
+	case "diagnose":
+		err = diagnose(flag.Arg(1))
+	case "prioritize":
+		err = prioritize(flag.Args()[1:])
 	case "rescan":
-		err = rescan(flag.Arg(1))
+		err = rescan(flag.Args()[1:])
 	case "override":
-		err = override(flag.Arg(1))
+		err = override(flag.Args()[1:])
 	case "revert":
-		err = revert(flag.Arg(1))
+		err = revert(flag.Args()[1:])
+	case "folder_remove":
+		err = folderRemove(flag.Args()[1:])
 	case "folder_pause":
-		err = api.PauseFolder(flag.Arg(1), true)
+		err = folderPause(flag.Arg(1), true)
 	case "folder_resume":
-		err = api.PauseFolder(flag.Arg(1), false)
+		err = folderPause(flag.Arg(1), false)
 	case "events":
 		err = events(flag.Arg(1), *limit, *since)
 	case "json_dump":
 		err = dumpDashAsJson()
+	case "profile":
+		err = profileTest()
+	case "config_export":
+		err = configExport()
+	case "config_import":
+		err = configImport(flag.Args()[1:])
+	case "config_diff":
+		err = configDiff("", flag.Arg(1))
+	case "apply":
+		err = apply(flag.Args()[1:])
+	case "options":
+		err = dumpOptions()
+	case "options_set":
+		err = optionsSet(flag.Args()[1:])
+	case "ratelimit":
+		err = ratelimit(flag.Args()[1:])
+	case "scheduler":
+		err = scheduler(flag.Args()[1:])
+	case "notify":
+		err = notify(flag.Args()[1:])
+	case "debug":
+		err = dumpDebug()
+	case "debug_enable":
+		err = debugSet(true, flag.Args()[1:])
+	case "debug_disable":
+		err = debugSet(false, flag.Args()[1:])
+	case "support_bundle":
+		err = supportBundle(flag.Args()[1:])
+	case "upgrade_check":
+		err = upgradeCheck()
+	case "upgrade":
+		err = upgrade(flag.Args()[1:])
+	case "agent":
+		err = agentCmd(flag.Args()[1:])
 	default:
-		err = dash()
+		switch *output {
+		case "json":
+			err = dumpDashAsJson()
+		case "table":
+			err = dash()
+		default:
+			err = fmt.Errorf("unknown output format %q", *output)
+		}
 	}
 
 	if err != nil {
@@ -0,0 +1,71 @@
+// syncthing cli tool - upgrades
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/tenox7/stc/api"
+)
+
+func upgradeCheck() error {
+	u, err := api.GetUpgrade()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Running: %v\nLatest:  %v\n", u.Running, u.Latest)
+	switch {
+	case u.MajorNewer:
+		fmt.Println("Major upgrade available, check release notes for compatibility")
+	case u.Newer:
+		fmt.Println("Upgrade available")
+	default:
+		fmt.Println("Up to date")
+	}
+	return nil
+}
+
+func upgrade(args []string) error {
+	fs := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	yes := fs.Bool("yes", false, "upgrade without asking")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	u, err := api.GetUpgrade()
+	if err != nil {
+		return err
+	}
+	if !u.Newer {
+		fmt.Printf("Already running latest %v\n", u.Running)
+		return nil
+	}
+	q := fmt.Sprintf("Upgrade from %v to %v?", u.Running, u.Latest)
+	if u.MajorNewer {
+		q = fmt.Sprintf("Upgrade from %v to %v, a major version?", u.Running, u.Latest)
+	}
+	if !*yes && !confirm(q) {
+		return fmt.Errorf("upgrade cancelled, use --yes to upgrade non-interactively")
+	}
+
+	st, err := api.GetSysStatus()
+	if err != nil {
+		return err
+	}
+	if err := api.Upgrade(); err != nil {
+		return err
+	}
+	fmt.Println("Upgrading, waiting for syncthing to restart")
+	if _, err := waitRestart(st, 5*time.Minute); err != nil {
+		return err
+	}
+	v, err := api.GetSysVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Syncthing is running %v\n", v.Version)
+	if v.Version != u.Latest {
+		return fmt.Errorf("expected version %v after upgrade", u.Latest)
+	}
+	return nil
+}